@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jcorbin/gothird/vm"
+)
+
+// debugger implements a line-oriented console around the vm stepping API.
+type debugger struct {
+	vm  *vm.VM
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+var errQuit = errors.New("debugger quit")
+
+const debugHelp = `commands:
+  break NAME|ADDR  set a breakpoint on a word or address
+  clear NAME|ADDR  remove a breakpoint
+  step             execute one instruction                     (alias: s)
+  next             step over calls                             (alias: n)
+  finish           run until the current word returns
+  continue         run until a breakpoint or halt              (alias: c)
+  stack            print the data stack
+  rstack           print the return stack
+  mem ADDR N       print N memory cells starting at ADDR
+  watch ADDR [N]   print accesses to N (default 1) cells at ADDR
+  dump             print a full VM dump
+  words            list dictionary words
+  quit             stop debugging                              (alias: q)
+`
+
+func (dbg debugger) run(ctx context.Context) error {
+	for {
+		fmt.Fprintf(dbg.out, "(debug) ")
+		if !dbg.in.Scan() {
+			if err := dbg.in.Err(); err != nil {
+				return err
+			}
+			return nil
+		}
+		fields := strings.Fields(dbg.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := dbg.do(ctx, fields[0], fields[1:]); err == errQuit {
+			return nil
+		} else if err == vm.ErrHalted {
+			fmt.Fprintf(dbg.out, "halted\n")
+		} else if errors.Is(err, vm.ErrHalted) {
+			fmt.Fprintf(dbg.out, "halted: %v\n", errors.Unwrap(err))
+		} else if err != nil {
+			fmt.Fprintf(dbg.out, "error: %v\n", err)
+		}
+	}
+}
+
+func (dbg debugger) do(ctx context.Context, cmd string, args []string) error {
+	switch cmd {
+	case "help", "?":
+		io.WriteString(dbg.out, debugHelp)
+
+	case "break", "b":
+		if len(args) != 1 {
+			return errors.New("usage: break NAME|ADDR")
+		}
+		if addr, err := strconv.ParseUint(args[0], 0, 0); err == nil {
+			dbg.vm.Break(uint(addr))
+		} else if _, err := dbg.vm.BreakWord(args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintf(dbg.out, "breakpoints: %v\n", dbg.vm.Breakpoints())
+
+	case "clear":
+		if len(args) != 1 {
+			return errors.New("usage: clear NAME|ADDR")
+		}
+		if addr, err := strconv.ParseUint(args[0], 0, 0); err == nil {
+			dbg.vm.ClearBreak(uint(addr))
+		} else if addr, err := dbg.vm.BreakWord(args[0]); err != nil {
+			return err
+		} else {
+			dbg.vm.ClearBreak(addr)
+		}
+		fmt.Fprintf(dbg.out, "breakpoints: %v\n", dbg.vm.Breakpoints())
+
+	case "step", "s":
+		return dbg.stepped(dbg.vm.Step())
+	case "next", "n":
+		return dbg.stepped(dbg.vm.StepOver(ctx))
+	case "finish":
+		return dbg.stepped(dbg.vm.StepOut(ctx))
+	case "continue", "c":
+		return dbg.stepped(dbg.vm.Continue(ctx))
+
+	case "stack":
+		fmt.Fprintf(dbg.out, "%v\n", dbg.vm.Stack())
+	case "rstack":
+		fmt.Fprintf(dbg.out, "%v\n", dbg.vm.RStack())
+
+	case "mem":
+		if len(args) != 2 {
+			return errors.New("usage: mem ADDR N")
+		}
+		addr, err := strconv.ParseUint(args[0], 0, 0)
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseUint(args[1], 0, strconv.IntSize-1)
+		if err != nil {
+			return err
+		}
+		values, err := dbg.vm.ReadMem(uint(addr), int(n))
+		if err != nil {
+			return err
+		}
+		for i, val := range values {
+			fmt.Fprintf(dbg.out, "  @%v %v\n", uint(addr)+uint(i), val)
+		}
+
+	case "watch":
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: watch ADDR [N]")
+		}
+		addr, err := strconv.ParseUint(args[0], 0, 0)
+		if err != nil {
+			return err
+		}
+		n := uint64(1)
+		if len(args) > 1 {
+			if n, err = strconv.ParseUint(args[1], 0, 0); err != nil {
+				return err
+			}
+		}
+		out := dbg.out
+		dbg.vm.Watch(uint(addr), uint(n), func(w vm.Watch) {
+			fmt.Fprintf(out, "watch: %v\n", w)
+		})
+
+	case "dump":
+		dbg.vm.Dump(dbg.out)
+
+	case "words":
+		fmt.Fprintf(dbg.out, "%v\n", strings.Join(dbg.vm.Words(), " "))
+
+	case "quit", "q":
+		return errQuit
+
+	default:
+		return fmt.Errorf("unknown command %q, try help", cmd)
+	}
+	return nil
+}
+
+func (dbg debugger) stepped(err error) error {
+	if err == nil {
+		dbg.where()
+	}
+	return err
+}
+
+func (dbg debugger) where() {
+	prog := dbg.vm.Prog()
+	code, _ := dbg.vm.Disasm(prog)
+	if name, offset := dbg.vm.WordOf(prog); name != "" {
+		fmt.Fprintf(dbg.out, "@%v %v+%v: %v\n", prog, name, offset, code)
+	} else {
+		fmt.Fprintf(dbg.out, "@%v: %v\n", prog, code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/gothird/vm"
+)
+
+func Test_debugger(t *testing.T) {
+	var out strings.Builder
+	require.NoError(t, debugger{
+		vm: vm.New(vm.WithInput(strings.NewReader(`exit : immediate _read @ ! - * / <0 echo key pick
+			: sq 0 pick * exit
+			: test immediate 3 sq exit
+			test
+		`))),
+		in: bufio.NewScanner(strings.NewReader(strings.Join([]string{
+			"break 1096",
+			"continue",
+			"stack",
+			"next",
+			"finish",
+			"stack",
+			"mem 1092 2",
+			"mem 0 -1",
+			"nope",
+			"continue",
+			"quit",
+		}, "\n"))),
+		out: &out,
+	}.run(context.Background()))
+
+	assert.Equal(t, strings.Join([]string{
+		"(debug) breakpoints: [1096]",
+		"(debug) @1096 sq+4: pushint(0)",
+		"(debug) [3]",
+		"(debug) @1098 sq+6: pick",
+		"(debug) @1107 test+6: exit",
+		"(debug) [9]",
+		"(debug)   @1092 1087",
+		"  @1093 14",
+		`(debug) error: strconv.ParseUint: parsing "-1": invalid syntax`,
+		"(debug) error: unknown command \"nope\", try help",
+		"(debug) halted: EOF",
+		"(debug) ",
+	}, "\n"), out.String())
+}
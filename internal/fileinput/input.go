@@ -8,10 +8,18 @@ import (
 	"github.com/jcorbin/gothird/internal/runeio"
 )
 
-// Location names an a line in an Input file.
+// Location names an a line in an Input file, or a position within one.
 type Location struct {
-	Name string
-	Line int
+	Name   string
+	Line   int
+	Column int // 1-based rune column within Line, 0 when naming the whole line
+	Offset int // byte offset from the start of the file
+}
+
+// Span names a range of an Input file, from Start up to but not including End.
+type Span struct {
+	Start Location
+	End   Location
 }
 
 // Line combines a Location along with a bytes.Buffer for handling it.
@@ -20,17 +28,35 @@ type Line struct {
 	bytes.Buffer
 }
 
-func (loc Location) String() string { return fmt.Sprintf("%v:%v", loc.Name, loc.Line) }
-func (il Line) String() string      { return fmt.Sprintf("%v %q", il.Location, il.Buffer.String()) }
+func (il Line) String() string { return fmt.Sprintf("%v %q", il.Location, il.Buffer.String()) }
+
+func (loc Location) String() string {
+	if loc.Column == 0 {
+		return fmt.Sprintf("%v:%v", loc.Name, loc.Line)
+	}
+	return fmt.Sprintf("%v:%v:%v", loc.Name, loc.Line, loc.Column)
+}
+
+func (sp Span) String() string {
+	if sp.Start.Name == sp.End.Name && sp.Start.Line == sp.End.Line {
+		return fmt.Sprintf("%v-%v", sp.Start, sp.End.Column)
+	}
+	return fmt.Sprintf("%v-%v", sp.Start, sp.End)
+}
 
 // Input implements sequential rune reading through a Queue of one or more
 // input streams. Both the current and last scanned lines are tracked to
-// facilitate user feedback.
+// facilitate user feedback, as are the positions of the last rune read and of
+// the next rune to be read.
 type Input struct {
-	rr    io.RuneReader
-	Queue []io.Reader
-	Last  Line
-	Scan  Line
+	rr      io.RuneReader
+	cl      io.Closer
+	stack   []inputFrame
+	Queue   []io.Reader
+	Last    Line
+	Scan    Line
+	RunePos Location
+	Pos     Location
 }
 
 // ReadRune reads one rune from the current input stream, appending it into the
@@ -41,10 +67,17 @@ func (in *Input) ReadRune() (rune, int, error) {
 	}
 
 	r, n, err := in.rr.ReadRune()
+	in.RunePos = in.Pos
+	in.Pos.Offset += n
 	if r == '\n' {
 		in.nextLine()
+		in.Pos.Line++
+		in.Pos.Column = 1
 	} else {
 		in.Scan.WriteRune(r)
+		if n > 0 {
+			in.Pos.Column++
+		}
 	}
 
 	if r != 0 {
@@ -65,20 +98,57 @@ func (in *Input) nextLine() {
 	in.Scan.Line++
 }
 
+// inputFrame saves the state of an Input stream interrupted by Push.
+type inputFrame struct {
+	rr   io.RuneReader
+	cl   io.Closer
+	line int
+	pos  Location
+}
+
+// Push splices r into the input at the current read position: it is read
+// until exhausted, after which reading resumes from the interrupted stream.
+// Pushes may nest; if r implements io.Closer, it is closed once exhausted.
+func (in *Input) Push(r io.Reader) {
+	if in.rr != nil {
+		in.stack = append(in.stack, inputFrame{in.rr, in.cl, in.Scan.Line, in.Pos})
+	}
+	in.nextLine()
+	in.open(r)
+	in.cl, _ = r.(io.Closer)
+}
+
+// Depth returns the number of input streams interrupted by Push.
+func (in *Input) Depth() int { return len(in.stack) }
+
+func (in *Input) open(r io.Reader) {
+	in.rr = runeio.NewReader(r)
+	in.Scan.Name = nameOf(r)
+	in.Scan.Line = 1
+	in.Pos = Location{Name: in.Scan.Name, Line: 1, Column: 1}
+}
+
 func (in *Input) nextIn() bool {
 	in.nextLine()
 	if in.rr != nil {
 		if cl, ok := in.rr.(io.Closer); ok {
 			cl.Close()
+		} else if in.cl != nil {
+			in.cl.Close()
 		}
-		in.rr = nil
+		in.rr, in.cl = nil, nil
 	}
-	if len(in.Queue) > 0 {
+	if i := len(in.stack) - 1; i >= 0 {
+		frame := in.stack[i]
+		in.stack = in.stack[:i]
+		in.rr, in.cl = frame.rr, frame.cl
+		in.Scan.Name = frame.pos.Name
+		in.Scan.Line = frame.line
+		in.Pos = frame.pos
+	} else if len(in.Queue) > 0 {
 		r := in.Queue[0]
 		in.Queue = in.Queue[1:]
-		in.rr = runeio.NewReader(r)
-		in.Scan.Name = nameOf(r)
-		in.Scan.Line = 1
+		in.open(r)
 	}
 	return in.rr != nil
 }
@@ -0,0 +1,221 @@
+// Package lineedit implements a minimal terminal line editor, with history
+// and tab completion, for use by interactive prompts.
+package lineedit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrInterrupt is returned by ReadLine after the user types Ctrl-C.
+var ErrInterrupt = errors.New("interrupt")
+
+// Editor reads lines from a terminal that has been put into a non-canonical,
+// non-echoing mode, echoing and editing them on Out itself.
+//
+// Supported editing keys are: left and right arrows, Ctrl-A and Ctrl-E to move
+// the cursor; backspace and Ctrl-U to delete; up and down arrows to navigate
+// history; tab to complete the word before the cursor; Ctrl-C to abandon the
+// line, and Ctrl-D to signal EOF on an empty line.
+type Editor struct {
+	In  io.RuneReader
+	Out io.Writer
+
+	// Complete, if not nil, is called with the word before the cursor, and
+	// should return any candidates that it may be completed to.
+	Complete func(prefix string) []string
+
+	History []string
+
+	line   []rune
+	cursor int
+	prompt string
+}
+
+// NewEditor creates an editor reading from in and echoing to out.
+func NewEditor(in io.Reader, out io.Writer) *Editor {
+	rr, ok := in.(io.RuneReader)
+	if !ok {
+		rr = bufio.NewReader(in)
+	}
+	return &Editor{In: rr, Out: out}
+}
+
+// ReadLine prints prompt, and then reads and returns a line of edited input,
+// adding any non-empty line to History.
+func (ed *Editor) ReadLine(prompt string) (string, error) {
+	ed.prompt = prompt
+	ed.line = ed.line[:0]
+	ed.cursor = 0
+	histID := len(ed.History)
+	ed.redraw()
+
+	for {
+		r, _, err := ed.In.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			io.WriteString(ed.Out, "\n")
+			line := string(ed.line)
+			if strings.TrimSpace(line) != "" {
+				ed.History = append(ed.History, line)
+			}
+			return line, nil
+
+		case 0x03: // Ctrl-C
+			io.WriteString(ed.Out, "^C\n")
+			return "", ErrInterrupt
+
+		case 0x04: // Ctrl-D
+			if len(ed.line) == 0 {
+				io.WriteString(ed.Out, "\n")
+				return "", io.EOF
+			}
+
+		case 0x01: // Ctrl-A
+			ed.cursor = 0
+		case 0x05: // Ctrl-E
+			ed.cursor = len(ed.line)
+
+		case 0x08, 0x7f: // backspace
+			if ed.cursor > 0 {
+				ed.line = append(ed.line[:ed.cursor-1], ed.line[ed.cursor:]...)
+				ed.cursor--
+			}
+
+		case 0x15: // Ctrl-U
+			ed.line = append(ed.line[:0], ed.line[ed.cursor:]...)
+			ed.cursor = 0
+
+		case '\t':
+			ed.complete()
+
+		case 0x1b: // ESC
+			switch ed.readEscape() {
+			case 'A': // up
+				if histID > 0 {
+					histID--
+					ed.setLine(ed.History[histID])
+				}
+			case 'B': // down
+				if histID < len(ed.History) {
+					histID++
+					if histID < len(ed.History) {
+						ed.setLine(ed.History[histID])
+					} else {
+						ed.setLine("")
+					}
+				}
+			case 'C': // right
+				if ed.cursor < len(ed.line) {
+					ed.cursor++
+				}
+			case 'D': // left
+				if ed.cursor > 0 {
+					ed.cursor--
+				}
+			}
+
+		default:
+			if unicode.IsPrint(r) {
+				ed.insert(r)
+			}
+		}
+
+		ed.redraw()
+	}
+}
+
+// readEscape reads the rest of a CSI escape sequence, returning its final
+// byte, or 0 for any other sequence.
+func (ed *Editor) readEscape() rune {
+	if r, _, err := ed.In.ReadRune(); err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	for {
+		r, _, err := ed.In.ReadRune()
+		if err != nil {
+			return 0
+		}
+		if r >= 0x40 && r <= 0x7e {
+			return r
+		}
+	}
+}
+
+func (ed *Editor) insert(rs ...rune) {
+	ed.line = append(ed.line, rs...)
+	copy(ed.line[ed.cursor+len(rs):], ed.line[ed.cursor:])
+	copy(ed.line[ed.cursor:], rs)
+	ed.cursor += len(rs)
+}
+
+func (ed *Editor) setLine(line string) {
+	ed.line = append(ed.line[:0], []rune(line)...)
+	ed.cursor = len(ed.line)
+}
+
+func (ed *Editor) complete() {
+	if ed.Complete == nil {
+		return
+	}
+
+	start := ed.cursor
+	for start > 0 && !unicode.IsSpace(ed.line[start-1]) {
+		start--
+	}
+	prefix := string(ed.line[start:ed.cursor])
+
+	var cands []string
+	for _, cand := range ed.Complete(prefix) {
+		if strings.HasPrefix(cand, prefix) {
+			cands = append(cands, cand)
+		}
+	}
+	switch len(cands) {
+	case 0:
+		return
+	case 1:
+		ed.insert([]rune(cands[0][len(prefix):] + " ")...)
+		return
+	}
+
+	sort.Strings(cands)
+	common := cands[0]
+	for _, cand := range cands[1:] {
+		for !strings.HasPrefix(cand, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	for !utf8.ValidString(common) {
+		common = common[:len(common)-1]
+	}
+	if len(common) > len(prefix) {
+		ed.insert([]rune(common[len(prefix):])...)
+		return
+	}
+
+	io.WriteString(ed.Out, "\n"+strings.Join(cands, " ")+"\n")
+}
+
+func (ed *Editor) redraw() {
+	var sb strings.Builder
+	sb.WriteString("\r")
+	sb.WriteString(ed.prompt)
+	sb.WriteString(string(ed.line))
+	sb.WriteString("\x1b[K")
+	if n := len(ed.line) - ed.cursor; n > 0 {
+		for i := 0; i < n; i++ {
+			sb.WriteString("\b")
+		}
+	}
+	io.WriteString(ed.Out, sb.String())
+}
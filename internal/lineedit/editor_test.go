@@ -0,0 +1,67 @@
+package lineedit_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/gothird/internal/lineedit"
+)
+
+func Test_Editor(t *testing.T) {
+	const (
+		up    = "\x1b[A"
+		down  = "\x1b[B"
+		right = "\x1b[C"
+		left  = "\x1b[D"
+		bs    = "\x7f"
+	)
+
+	ed := lineedit.NewEditor(strings.NewReader(strings.Join([]string{
+		"hello",
+		"wrld" + left + left + left + "o",
+		"abc" + bs + bs + "x\x01>\x05<",
+		up + up + bs + "!",
+		up + down + down + "new",
+		"dr\tsw\t",
+		"pr\t\t",
+		"gone\x03\x04",
+	}, "\n")), ioutil.Discard)
+	ed.Complete = func(prefix string) []string {
+		return []string{"drop", "dup", "swap", "print", "printnum"}
+	}
+
+	for _, expect := range []string{
+		"hello",
+		"world",
+		">ax<",
+		"worl!",
+		"new",
+		"drop swap ",
+		"print",
+	} {
+		line, err := ed.ReadLine("> ")
+		require.NoError(t, err, "unexpected read error")
+		assert.Equal(t, expect, line, "expected line")
+	}
+
+	_, err := ed.ReadLine("> ")
+	assert.Equal(t, lineedit.ErrInterrupt, err, "expected interrupt")
+
+	_, err = ed.ReadLine("> ")
+	assert.Equal(t, io.EOF, err, "expected EOF")
+
+	assert.Equal(t, []string{
+		"hello",
+		"world",
+		">ax<",
+		"worl!",
+		"new",
+		"drop swap ",
+		"print",
+	}, ed.History, "expected history")
+}
@@ -10,8 +10,14 @@ type PagedCore struct {
 	// Limit specifies a limit, past which any store or load should result in an error.
 	Limit uint
 
-	bases []uint
-	sizes []uint
+	bases   []uint
+	sizes   []uint
+	regions []region
+	watches int
+
+	// gen counts changes that invalidate any IntsCache: pages copied or
+	// coalesced, pages shared by a fork, and protection or watches applied
+	gen uint64
 }
 
 // LimitError indicates that a memory operation, like load or store, exceeded a limit.
@@ -24,6 +30,23 @@ func (lim LimitError) Error() string {
 	return fmt.Sprintf("memory limit exceeded by %v @%v", lim.Op, lim.Addr)
 }
 
+// fork returns a copy of m that doesn't alias its slices, without any
+// watched regions.
+func (m *PagedCore) fork() PagedCore {
+	fork := PagedCore{
+		PageSize: m.PageSize,
+		Limit:    m.Limit,
+		bases:    append([]uint(nil), m.bases...),
+		sizes:    append([]uint(nil), m.sizes...),
+	}
+	for _, r := range m.regions {
+		if r.watch == nil {
+			fork.regions = append(fork.regions, r)
+		}
+	}
+	return fork
+}
+
 func (m *PagedCore) findPage(addr uint) int {
 	i, j := 0, len(m.bases)
 	for i < j {
@@ -73,6 +96,13 @@ func (m *PagedCore) allocPage(pageID int, addr uint) (base, size uint, isNew boo
 	return base, m.sizes[pageID], false
 }
 
+// merge coalesces page pageID+1 into page pageID, which must be contiguous.
+func (m *PagedCore) merge(pageID int) {
+	m.sizes[pageID] += m.sizes[pageID+1]
+	m.bases = append(m.bases[:pageID+1], m.bases[pageID+2:]...)
+	m.sizes = append(m.sizes[:pageID+1], m.sizes[pageID+2:]...)
+}
+
 func (m *PagedCore) checkLimit(addr uint, op string) error {
 	if maxSize := m.Limit; maxSize != 0 && addr > maxSize {
 		return LimitError{addr, op}
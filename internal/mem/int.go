@@ -7,7 +7,72 @@ const DefaultIntsPageSize = 255
 // Pages may not necessarily be the same size, but usually are in practice.
 type Ints struct {
 	PagedCore
-	pages [][]int
+	pages  [][]int
+	shared []bool // pages shared with a Fork, copied before any write
+
+	loads, stors uint64 // operation counts, see Stats
+}
+
+// IntsStats reports memory usage, see Ints.Stats.
+type IntsStats struct {
+	Pages  int    // number of allocated pages
+	Shared int    // number of pages still shared with a Fork
+	Cells  uint   // number of cells in all allocated pages
+	Loads  uint64 // number of successful Load and LoadInto calls
+	Stors  uint64 // number of successful Stor calls
+}
+
+// Stats returns memory usage statistics; accesses made through an IntsCache
+// are not counted, see IntsCache.Stats.
+func (m *Ints) Stats() IntsStats {
+	stats := IntsStats{
+		Pages: len(m.pages),
+		Loads: m.loads,
+		Stors: m.stors,
+	}
+	for _, size := range m.sizes {
+		stats.Cells += size
+	}
+	for _, shared := range m.shared {
+		if shared {
+			stats.Shared++
+		}
+	}
+	return stats
+}
+
+// Fork returns a copy of m that shares all of its allocated pages
+// copy-on-write, so that stores into either one are invisible to the other.
+// Protection is inherited by the fork, but watches are not.
+// Neither m nor the fork may be used concurrently with Fork itself, but may
+// be once it returns.
+func (m *Ints) Fork() Ints {
+	if len(m.shared) < len(m.pages) {
+		m.shared = make([]bool, len(m.pages))
+	}
+	for i := range m.shared {
+		m.shared[i] = true
+	}
+	m.gen++
+	return Ints{
+		PagedCore: m.PagedCore.fork(),
+		pages:     append([][]int(nil), m.pages...),
+		shared:    append([]bool(nil), m.shared...),
+	}
+}
+
+func (m *Ints) isShared(pageID int) bool { return m.shared != nil && m.shared[pageID] }
+
+// own returns the page with the given id, copying it first if it's shared.
+func (m *Ints) own(pageID int) []int {
+	page := m.pages[pageID]
+	if m.isShared(pageID) {
+		page = append([]int(nil), page...)
+		m.pages[pageID] = page
+		m.shared[pageID] = false
+		m.gen++
+	}
+	return page
 }
 
 // Size returns an address one position higher than the last position in the
@@ -26,19 +91,30 @@ func (m *Ints) Load(addr uint) (int, error) {
 	if err := m.checkLimit(addr, "load"); err != nil {
 		return 0, err
 	}
+	if err := m.checkProt(addr, addr+1, NoRead, "load"); err != nil {
+		return 0, err
+	}
 
+	m.loads++
 	if m.PageSize == 0 || len(m.pages) == 0 {
 		return 0, nil
 	}
 
+	val := m.load(addr)
+	if m.watched(addr, addr+1) {
+		m.notify("load", addr, []int{val}, []int{val})
+	}
+	return val, nil
+}
+
+func (m *Ints) load(addr uint) int {
 	pageID := m.findPage(addr)
 	base := m.bases[pageID]
 	page := m.pages[pageID]
 	if i := int(addr) - int(base); 0 <= i && i < len(page) {
-		return page[i], nil
+		return page[i]
 	}
-
-	return 0, nil
+	return 0
 }
 
 // LoadInto reads len(buf) integers from memory starting at addr.
@@ -53,7 +129,20 @@ func (m *Ints) LoadInto(addr uint, buf []int) error {
 	if err := m.checkLimit(end, "load"); err != nil {
 		return err
 	}
+	if err := m.checkProt(addr, end, NoRead, "load"); err != nil {
+		return err
+	}
+
+	m.loads++
+	m.loadInto(addr, buf)
+	if m.watched(addr, end) {
+		m.notify("load", addr, buf, buf)
+	}
+	return nil
+}
 
+func (m *Ints) loadInto(addr uint, buf []int) {
+	end := addr + uint(len(buf))
 	for pageID := m.findPage(addr); addr < end && pageID < len(m.bases); pageID++ {
 		base := m.bases[pageID]
 		if base > end {
@@ -88,8 +177,6 @@ func (m *Ints) LoadInto(addr uint, buf []int) error {
 	for i := range buf {
 		buf[i] = 0
 	}
-
-	return nil
 }
 
 // Stor stores any values at addr, allocating pages if necessary.
@@ -103,13 +190,25 @@ func (m *Ints) Stor(addr uint, values ...int) error {
 	if err := m.checkLimit(end, "stor"); err != nil {
 		return err
 	}
+	if err := m.checkProt(addr, end, NoWrite, "stor"); err != nil {
+		return err
+	}
 
 	if m.PageSize == 0 {
 		m.PageSize = DefaultIntsPageSize
 	}
 
+	m.stors++
+	if m.watched(addr, end) {
+		olds := make([]int, len(values))
+		m.loadInto(addr, olds)
+		defer m.notify("stor", addr, olds, values)
+	}
+
 	for pageID := m.findPage(addr); addr < end; pageID++ {
-		base, size, page := m.allocPage(pageID, addr)
+		var base, size uint
+		var page []int
+		pageID, base, size, page = m.allocPage(pageID, addr)
 		if skip := addr - base; skip > 0 {
 			if skip >= size {
 				continue
@@ -125,10 +224,28 @@ func (m *Ints) Stor(addr uint, values ...int) error {
 	return nil
 }
 
-func (m *Ints) allocPage(pageID int, addr uint) (base, size uint, page []int) {
+// allocPage returns the page for addr, allocating a new one if necessary, see
+// PagedCore.allocPage. A new page that is contiguous with the one before it
+// extends that page instead, if it has capacity to spare; otherwise the new
+// page is allocated with twice that page's size in capacity, so that
+// contiguous runs of memory coalesce into geometrically larger pages.
+// Any merged page's id is returned.
+func (m *Ints) allocPage(pageID int, addr uint) (_ int, base, size uint, page []int) {
 	base, size, isNew := m.PagedCore.allocPage(pageID, addr)
 	if isNew {
-		page = make([]int, size)
+		capacity := size
+		if prev := pageID - 1; prev >= 0 && m.bases[prev]+m.sizes[prev] == base {
+			if page = m.pages[prev]; !m.isShared(prev) && uint(cap(page)-len(page)) >= size {
+				page = page[:len(page)+int(size)]
+				m.pages[prev] = page
+				m.PagedCore.merge(prev)
+				return prev, m.bases[prev], m.sizes[prev], page
+			}
+			if double := 2 * m.sizes[prev]; double > capacity {
+				capacity = double
+			}
+		}
+		page = make([]int, size, capacity)
 		if pageID == len(m.bases) {
 			m.pages = append(m.pages, page)
 		} else {
@@ -136,8 +253,157 @@ func (m *Ints) allocPage(pageID int, addr uint) (base, size uint, page []int) {
 			copy(m.pages[pageID+1:], m.pages[pageID:])
 			m.pages[pageID] = page
 		}
+		if m.shared != nil {
+			m.shared = append(m.shared, false)
+			copy(m.shared[pageID+1:], m.shared[pageID:])
+			m.shared[pageID] = false
+		}
 	} else {
-		page = m.pages[pageID]
+		page = m.own(pageID)
+	}
+	return pageID, base, size, page
+}
+
+// Compact coalesces each run of contiguous pages into a single page, so that
+// later accesses have fewer pages to search; pages shared by Fork are copied if
+// merged.
+func (m *Ints) Compact() {
+	m.gen++
+	n := 0
+	for i := 0; i < len(m.pages); {
+		base, end := m.bases[i], m.bases[i]+m.sizes[i]
+		j := i + 1
+		for j < len(m.pages) && m.bases[j] == end {
+			end += m.sizes[j]
+			j++
+		}
+
+		page := m.pages[i]
+		shared := m.isShared(i)
+		if j-i > 1 {
+			// reuse any spare capacity of the run's first page, so that only
+			// the rest of the run need be copied
+			if shared || uint(cap(page)) < end-base {
+				page = append(make([]int, 0, end-base), page...)
+			}
+			for _, run := range m.pages[i+1 : j] {
+				page = append(page, run...)
+			}
+			shared = false
+		}
+
+		m.bases[n], m.sizes[n], m.pages[n] = base, end-base, page
+		if m.shared != nil {
+			m.shared[n] = shared
+		}
+		n++
+		i = j
+	}
+
+	for i := n; i < len(m.pages); i++ {
+		m.pages[i] = nil
+	}
+	m.bases, m.sizes, m.pages = m.bases[:n], m.sizes[:n], m.pages[:n]
+	if m.shared != nil {
+		m.shared = m.shared[:n]
+	}
+}
+
+// EachPage calls fn with the base address and values of each allocated page,
+// in address order, stopping at the first error returned.
+func (m *Ints) EachPage(fn func(base uint, page []int) error) error {
+	for i, page := range m.pages {
+		if err := fn(m.bases[i], page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Page returns the allocated page containing addr, and its base address;
+// page is nil if addr is unallocated, exceeds any Limit, is unreadable, or is
+// watched.
+// The returned page aliases memory, and is truncated to any Limit, and to the
+// range around addr that has uniform protection, which is also returned.
+// A page shared by Fork must not be written through, but stored to by Stor,
+// which copies it first.
+func (m *Ints) Page(addr uint) (base uint, page []int, prot Protection) {
+	base, page, prot, _ = m.page(addr)
+	return base, page, prot
+}
+
+func (m *Ints) page(addr uint) (base uint, page []int, prot Protection, shared bool) {
+	if len(m.pages) == 0 || (m.Limit != 0 && addr >= m.Limit) {
+		return 0, nil, 0, false
+	}
+	pageID := m.findPage(addr)
+	base, page = m.bases[pageID], m.pages[pageID]
+	if addr < base || addr-base >= uint(len(page)) {
+		return 0, nil, 0, false
+	}
+	end := base + uint(len(page))
+	if m.Limit != 0 && end > m.Limit {
+		end = m.Limit
 	}
-	return base, size, page
+	lo, hi := base, end
+	if len(m.regions) > 0 {
+		if lo, hi, prot = m.span(addr, lo, hi); prot&(NoRead|watched) != 0 {
+			return 0, nil, prot &^ watched, false
+		}
+	}
+	return lo, page[lo-base : hi-base], prot, m.isShared(pageID)
+}
+
+// IntsCache caches a single page of Ints, so that repeated accesses near the
+// same address needn't search for their page. Its Load and Stor methods only
+// access the cached page, returning false on a miss; callers should then Fill
+// the cache and try again, falling back to Ints itself if the cell is still
+// unavailable, e.g. because it's unallocated, or shared by Fork.
+// The cache misses after any change to its Ints that may invalidate the cached
+// page, like copying it, or protecting it.
+type IntsCache struct {
+	mem      *Ints
+	gen      uint64
+	base     uint
+	page     []int
+	readOnly bool
+
+	loads, stors uint64 // hit counts, see Stats
 }
+
+// Stats returns the number of loads and stores that hit the cache.
+func (c *IntsCache) Stats() (loads, stors uint64) { return c.loads, c.stors }
+
+// Load returns the cached value at addr, if any.
+func (c *IntsCache) Load(addr uint) (int, bool) {
+	if i := addr - c.base; i < uint(len(c.page)) && c.gen == c.mem.gen {
+		c.loads++
+		return c.page[i], true
+	}
+	return 0, false
+}
+
+// Stor stores val at addr, returning false if addr isn't cached, is protected
+// from writes, or is shared by Fork.
+func (c *IntsCache) Stor(addr uint, val int) bool {
+	if i := addr - c.base; i < uint(len(c.page)) && !c.readOnly && c.gen == c.mem.gen {
+		c.page[i] = val
+		c.stors++
+		return true
+	}
+	return false
+}
+
+// Fill caches the page of m containing addr, returning false if there is
+// none, see Ints.Page.
+func (c *IntsCache) Fill(m *Ints, addr uint) bool {
+	var prot Protection
+	var shared bool
+	c.base, c.page, prot, shared = m.page(addr)
+	c.mem, c.gen = m, m.gen
+	c.readOnly = prot&NoWrite != 0 || shared
+	return c.page != nil
+}
+
+// Reset clears the cache; hit counts are kept.
+func (c *IntsCache) Reset() { c.mem, c.gen, c.base, c.page, c.readOnly = nil, 0, 0, nil, false }
@@ -258,3 +258,342 @@ func (step memCoreTestStep) bind(m *mem.Ints) func(t *testing.T) {
 func (step memCoreTestStep) boundTest(t *testing.T) {
 	step.f(t, step.m)
 }
+
+func Test_IntsCache(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 4
+	m.Limit = 10
+
+	var c mem.IntsCache
+	require.False(t, c.Fill(&m, 1), "expected no page in unallocated memory")
+	_, ok := c.Load(1)
+	require.False(t, ok, "expected load miss")
+
+	require.NoError(t, m.Stor(1, 5))
+	require.True(t, c.Fill(&m, 1), "expected page after store")
+	val, ok := c.Load(1)
+	require.True(t, ok, "expected load hit")
+	require.Equal(t, 5, val, "expected stored value")
+
+	require.True(t, c.Stor(1, 7), "expected store hit")
+	val, err := m.Load(1)
+	require.NoError(t, err, "unexpected load error")
+	require.Equal(t, 7, val, "expected store through cache")
+
+	require.NoError(t, m.Stor(2, 9))
+	val, _ = c.Load(2)
+	require.Equal(t, 9, val, "expected cached page to alias memory")
+	require.False(t, c.Stor(4, 1), "expected store miss past page")
+
+	require.NoError(t, m.Stor(8, 1))
+	require.True(t, c.Fill(&m, 9), "expected page within limit")
+	require.False(t, c.Stor(10, 1), "expected store miss at limit")
+	require.False(t, c.Fill(&m, 10), "expected no page at limit")
+	require.False(t, c.Fill(&m, 5), "expected no page in a hole")
+}
+
+func benchInts(size uint) *mem.Ints {
+	m := &mem.Ints{}
+	m.PageSize = 256
+	if err := m.Stor(0, make([]int, size)...); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func BenchmarkInts_Load(b *testing.B) {
+	m := benchInts(16 * 256)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Load(uint(i*7) % (16 * 256))
+	}
+}
+
+func BenchmarkInts_LoadCompacted(b *testing.B) {
+	m := benchInts(16 * 256)
+	m.Compact()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		m.Load(uint(i*7) % (16 * 256))
+	}
+}
+
+func BenchmarkInts_Stor(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		n    int
+		addr uint
+	}{
+		{"single", 1, 100},
+		{"within page", 8, 100},
+		{"across page", 8, 252},
+		{"across pages", 600, 200},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := benchInts(16 * 256)
+			values := make([]int, bc.n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Stor(bc.addr, values...)
+			}
+		})
+	}
+}
+
+func BenchmarkInts_LoadInto(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		n    int
+		addr uint
+	}{
+		{"within page", 8, 100},
+		{"across page", 8, 252},
+		{"across pages", 600, 200},
+		{"unallocated", 600, 16 * 256},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			m := benchInts(16 * 256)
+			buf := make([]int, bc.n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.LoadInto(bc.addr, buf)
+			}
+		})
+	}
+}
+
+func Test_Ints_Protect(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 8
+	require.NoError(t, m.Stor(0, 1, 2, 3, 4, 5, 6, 7, 8))
+	m.Protect(2, 4, mem.ReadOnly)
+	m.Protect(6, 7, mem.NoAccess)
+
+	require.Equal(t, mem.ReadOnly, m.Protected(3), "expected read-only protection")
+	require.Equal(t, mem.Protection(0), m.Protected(4), "expected no protection")
+
+	val, err := m.Load(3)
+	require.NoError(t, err, "expected read-only load")
+	require.Equal(t, 4, val, "expected read-only value")
+
+	require.Equal(t, mem.ProtectionError{Addr: 3, Op: "stor"}, m.Stor(3, 9), "expected read-only stor error")
+	require.Equal(t, mem.ProtectionError{Addr: 2, Op: "stor"}, m.Stor(1, 9, 9), "expected overlapping stor error")
+	require.NoError(t, m.Stor(4, 9), "expected unprotected stor")
+
+	_, err = m.Load(6)
+	require.Equal(t, mem.ProtectionError{Addr: 6, Op: "load"}, err, "expected no-access load error")
+	require.Equal(t, mem.ProtectionError{Addr: 6, Op: "load"}, m.LoadInto(4, make([]int, 4)), "expected no-access load error")
+
+	var c mem.IntsCache
+	require.True(t, c.Fill(&m, 1), "expected page below read-only region")
+	require.True(t, c.Stor(1, 10), "expected cached stor below read-only region")
+	require.False(t, c.Stor(2, 10), "expected cache to stop short of read-only region")
+	require.True(t, c.Fill(&m, 3), "expected read-only page")
+	val, _ = c.Load(2)
+	require.Equal(t, 3, val, "expected cached read-only value")
+	require.False(t, c.Stor(3, 10), "expected no cached stor into read-only region")
+	require.False(t, c.Fill(&m, 6), "expected no page for no-access region")
+}
+
+func Test_Ints_Watch(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 8
+	require.NoError(t, m.Stor(0, 1, 2, 3, 4, 5, 6, 7, 8))
+
+	type access struct {
+		op       string
+		addr     uint
+		old, new int
+	}
+	var got []access
+	m.Watch(2, 4, func(op string, addr uint, old, new int) {
+		got = append(got, access{op, addr, old, new})
+	})
+
+	require.NoError(t, m.Stor(1, 9, 9, 9, 9, 9))
+	_, err := m.Load(3)
+	require.NoError(t, err)
+	_, err = m.Load(4)
+	require.NoError(t, err)
+	require.NoError(t, m.LoadInto(0, make([]int, 3)))
+	require.Equal(t, []access{
+		{"stor", 2, 3, 9},
+		{"stor", 3, 4, 9},
+		{"load", 3, 9, 9},
+		{"load", 2, 9, 9},
+	}, got, "expected watched accesses")
+
+	var c mem.IntsCache
+	require.True(t, c.Fill(&m, 1), "expected page below watch")
+	require.False(t, c.Stor(2, 0), "expected cache to stop short of watch")
+	require.False(t, c.Fill(&m, 2), "expected no page for watched cells")
+}
+
+func Test_Ints_Fork(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 4
+	require.NoError(t, m.Stor(0, 1, 2, 3, 4, 5, 6))
+	m.Protect(7, 8, mem.ReadOnly)
+	watched := 0
+	m.Watch(0, 8, func(op string, addr uint, old, new int) { watched++ })
+
+	fork := m.Fork()
+	require.NoError(t, fork.Stor(1, 9))
+	require.NoError(t, fork.Stor(12, 7))
+	require.Equal(t, mem.ProtectionError{Addr: 7, Op: "stor"}, fork.Stor(7, 0))
+
+	var c mem.IntsCache
+	require.True(t, c.Fill(&fork, 4), "expected page")
+	require.True(t, c.Stor(5, 8), "expected cached store")
+
+	buf := make([]int, 6)
+	require.NoError(t, fork.LoadInto(0, buf))
+	require.Equal(t, []int{1, 9, 3, 4, 5, 8}, buf, "expected fork values")
+	require.Equal(t, uint(16), fork.Size(), "expected fork size")
+	require.Equal(t, 0, watched, "expected fork to not inherit watches")
+
+	require.NoError(t, m.Stor(2, 0))
+	require.NoError(t, m.LoadInto(0, buf))
+	require.Equal(t, []int{1, 2, 0, 4, 5, 6}, buf, "expected original values")
+	require.Equal(t, uint(8), m.Size(), "expected original size")
+	require.NoError(t, fork.LoadInto(0, buf))
+	require.Equal(t, []int{1, 9, 3, 4, 5, 8}, buf, "expected fork values unchanged")
+}
+
+func Test_Ints_Fork_readOnly(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 4
+	require.NoError(t, m.Stor(0, 1, 2, 3))
+	require.NoError(t, m.Stor(8, 4, 5, 6))
+
+	var mc mem.IntsCache
+	require.True(t, mc.Fill(&m, 1), "expected page")
+	require.True(t, mc.Stor(1, 7), "expected cached store before fork")
+
+	fork := m.Fork()
+	_, hit := mc.Load(1)
+	require.False(t, hit, "expected cache to miss after fork")
+	require.True(t, mc.Fill(&m, 1), "expected page")
+	require.False(t, mc.Stor(1, 2), "expected no cached store into a shared page")
+
+	var c mem.IntsCache
+	for _, addr := range []uint{0, 1, 2, 8, 9, 10} {
+		if _, hit := c.Load(addr); !hit {
+			require.True(t, c.Fill(&fork, addr), "expected page for %v", addr)
+		}
+		_, hit := c.Load(addr)
+		require.True(t, hit, "expected cached load of %v", addr)
+	}
+	val, err := fork.Load(9)
+	require.NoError(t, err)
+	require.Equal(t, 5, val)
+	require.Equal(t, 2, fork.Stats().Shared, "expected read-only fork to leave pages shared")
+
+	require.NoError(t, fork.Stor(9, 0))
+	require.Equal(t, 1, fork.Stats().Shared, "expected stored page to be copied")
+	val, hit = c.Load(9)
+	require.False(t, hit, "expected cache to miss after copy")
+	require.True(t, c.Fill(&fork, 9), "expected page")
+	val, hit = c.Load(9)
+	require.True(t, hit, "expected cached load")
+	require.Equal(t, 0, val, "expected fork value")
+	val, err = m.Load(9)
+	require.NoError(t, err)
+	require.Equal(t, 5, val, "expected original value")
+}
+
+func Test_Ints_Stats(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 4
+	require.Equal(t, mem.IntsStats{}, m.Stats(), "expected empty stats")
+
+	require.NoError(t, m.Stor(2, 1, 2, 3))
+	require.NoError(t, m.Stor(9, 4))
+	_, err := m.Load(3)
+	require.NoError(t, err)
+	require.NoError(t, m.LoadInto(0, make([]int, 8)))
+	require.Equal(t, mem.IntsStats{
+		Pages: 2,
+		Cells: 12,
+		Loads: 2,
+		Stors: 2,
+	}, m.Stats(), "expected stats")
+
+	var c mem.IntsCache
+	require.True(t, c.Fill(&m, 2), "expected page")
+	c.Load(2)
+	c.Load(3)
+	c.Stor(3, 5)
+	loads, stors := c.Stats()
+	require.Equal(t, [2]uint64{2, 1}, [2]uint64{loads, stors}, "expected cache hit counts")
+}
+
+func Test_Ints_merge(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 4
+	for addr := uint(0); addr < 64; addr++ {
+		require.NoError(t, m.Stor(addr, int(addr)))
+	}
+	d := m.Dump()
+	require.Equal(t, []uint{0, 4, 12, 28, 60}, d.Bases, "expected contiguous pages to coalesce geometrically")
+	require.Equal(t, []uint{4, 8, 16, 32, 4}, d.Sizes, "expected contiguous pages to coalesce geometrically")
+
+	buf := make([]int, 64)
+	require.NoError(t, m.LoadInto(0, buf))
+	for i, val := range buf {
+		require.Equal(t, i, val, "expected value @%v", i)
+	}
+
+	var g mem.Ints
+	g.PageSize = 4
+	require.NoError(t, g.Stor(0, 1))
+	require.NoError(t, g.Stor(8, 3))
+	require.NoError(t, g.Stor(4, 2))
+	require.Equal(t, mem.IntsDump{
+		Bases: []uint{0, 4, 8},
+		Sizes: []uint{4, 4, 4},
+		Pages: [][]int{{1, 0, 0, 0}, {2, 0, 0, 0}, {3, 0, 0, 0}},
+	}, g.Dump(), "expected a gap filled without capacity to spare")
+	require.NoError(t, g.Stor(12, 4))
+	require.NoError(t, g.Stor(16, 5))
+	require.Equal(t, []uint{0, 4, 8, 12}, g.Dump().Bases, "expected a later page to extend its predecessor")
+}
+
+func Test_Ints_Compact(t *testing.T) {
+	var m mem.Ints
+	m.PageSize = 4
+	require.NoError(t, m.Stor(0, 1, 2, 3, 4, 5, 6))
+	require.NoError(t, m.Stor(13, 7))
+	fork := m.Fork()
+
+	m.Compact()
+	require.Equal(t, mem.IntsDump{
+		Bases: []uint{0x0, 0xc},
+		Sizes: []uint{8, 4},
+		Pages: [][]int{
+			{1, 2, 3, 4, 5, 6, 0, 0},
+			{0, 7, 0, 0},
+		},
+	}, m.Dump(), "expected contiguous pages to coalesce")
+
+	require.NoError(t, m.Stor(16, 9))
+	m.Compact()
+	require.Equal(t, mem.IntsDump{
+		Bases: []uint{0x0, 0xc},
+		Sizes: []uint{8, 8},
+		Pages: [][]int{
+			{1, 2, 3, 4, 5, 6, 0, 0},
+			{0, 7, 0, 0, 9, 0, 0, 0},
+		},
+	}, m.Dump(), "expected a new page to coalesce")
+
+	require.NoError(t, m.Stor(4, 0))
+	val, err := fork.Load(4)
+	require.NoError(t, err)
+	require.Equal(t, 5, val, "expected fork to be unaffected")
+	require.Equal(t, 3, fork.Stats().Pages, "expected fork pages to be uncompacted")
+
+	val, err = m.Load(4)
+	require.NoError(t, err)
+	require.Equal(t, 0, val, "expected compacted store")
+}
@@ -0,0 +1,142 @@
+package mem
+
+import "fmt"
+
+// Protection restricts access to a region of memory.
+type Protection uint8
+
+const (
+	// NoWrite causes any store into a region to fail.
+	NoWrite Protection = 1 << iota
+
+	// NoRead causes any load from a region to fail.
+	NoRead
+
+	// ReadOnly is an alias for NoWrite.
+	ReadOnly = NoWrite
+
+	// NoAccess denies both loads and stores.
+	NoAccess = NoRead | NoWrite
+
+	// watched marks regions with a WatchFunc, that mustn't be cached.
+	watched Protection = 1 << 7
+)
+
+// ProtectionError indicates that a memory operation, like load or store,
+// violated a protected region.
+type ProtectionError struct {
+	Addr uint
+	Op   string
+}
+
+func (prot ProtectionError) Error() string {
+	return fmt.Sprintf("memory protection violated by %v @%v", prot.Op, prot.Addr)
+}
+
+type region struct {
+	start, end uint
+	prot       Protection
+	watch      WatchFunc
+}
+
+// WatchFunc is called after any load or store of a watched address, with the
+// operation ("load" or "stor"), and the prior and current value at addr.
+type WatchFunc func(op string, addr uint, old, new int)
+
+// Watch calls fn after every load from, or store to, any address in
+// [start, end). Watched addresses are never cached, see Page.
+func (m *PagedCore) Watch(start, end uint, fn WatchFunc) {
+	if start < end && fn != nil {
+		m.regions = append(m.regions, region{start, end, watched, fn})
+		m.watches++
+		m.gen++
+	}
+}
+
+func (m *PagedCore) watched(addr, end uint) bool {
+	if m.watches == 0 {
+		return false
+	}
+	for _, r := range m.regions {
+		if r.watch != nil && r.start < end && addr < r.end {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *PagedCore) notify(op string, addr uint, olds, news []int) {
+	end := addr + uint(len(news))
+	for _, r := range m.regions {
+		if r.watch == nil {
+			continue
+		}
+		lo, hi := r.start, r.end
+		if lo < addr {
+			lo = addr
+		}
+		if hi > end {
+			hi = end
+		}
+		for a := lo; a < hi; a++ {
+			r.watch(op, a, olds[a-addr], news[a-addr])
+		}
+	}
+}
+
+// Protect applies prot to all addresses in [start, end), in addition to
+// any protection already applied by a prior call.
+func (m *PagedCore) Protect(start, end uint, prot Protection) {
+	if prot &^= watched; start < end && prot != 0 {
+		m.regions = append(m.regions, region{start, end, prot, nil})
+		m.gen++
+	}
+}
+
+// Protected returns the protection applied to addr.
+func (m *PagedCore) Protected(addr uint) (prot Protection) {
+	for _, r := range m.regions {
+		if r.start <= addr && addr < r.end {
+			prot |= r.prot
+		}
+	}
+	return prot &^ watched
+}
+
+func (m *PagedCore) checkProt(addr, end uint, deny Protection, op string) error {
+	for _, r := range m.regions {
+		if r.prot&deny != 0 && r.start < end && addr < r.end {
+			if addr < r.start {
+				addr = r.start
+			}
+			return ProtectionError{addr, op}
+		}
+	}
+	return nil
+}
+
+// span narrows [lo, hi), which must contain addr, to the range around addr
+// that has uniform protection, returning that protection.
+func (m *PagedCore) span(addr, lo, hi uint) (_, _ uint, prot Protection) {
+	for _, r := range m.regions {
+		switch {
+		case r.start <= addr && addr < r.end:
+			prot |= r.prot
+			if lo < r.start {
+				lo = r.start
+			}
+			if hi > r.end {
+				hi = r.end
+			}
+		case r.end <= addr:
+			if lo < r.end {
+				lo = r.end
+			}
+		default:
+			if hi > r.start {
+				hi = r.start
+			}
+		}
+	}
+	return lo, hi, prot
+}
@@ -1,94 +1,60 @@
-/* Package main: FIRST & THIRD -- almost FORTH
-
-FORTH is a language mostly familiar to users of "small" machines. FORTH
-programs are small because they are interpreted--a function call in FORTH takes
-two bytes.  FORTH is an extendable language-- built-in primitives are
-indistinguishable from user-defined _words_.  FORTH interpreters are small
-because much of the system can be coded in FORTH--only a small number of
-primitives need to be implemented.  Some FORTH interpreters can also compile
-defined words into machine code, resulting in a fast system.
-
-FIRST is an incredibly small language which is sufficient for defining the
-language THIRD, which is mostly like FORTH.  There are some differences, and
-THIRD is probably just enough like FORTH for those differences to be disturbing
-to regular FORTH users.
-
-The only existing FIRST interpreter is written in obfuscated C, and rings in at
-under 800 bytes of source code, although through deletion of whitespace and
-unobfuscation it can be brought to about 650 bytes.
-
-This document FIRST defines the FIRST environment and primitives, with relevent
-design decision explanations.  It secondly documents the general strategies we
-will use to implement THIRD.  The THIRD section demonstrates how the complete
-THIRD system is built up using FIRST.
-
-Section 1: see first.go
-
-Section 2: Motivating THIRD
-
-What is missing from FIRST?  There are a large number of important primitives
-that aren't implemented, but which are easy to implement.  drop , which throws
-away the top of the stack, can be implemented as { 0 * + } -- that is, multiply
-the top of the stack by 0 (which turns the top of the stack into a 0), and then
-add the top two elements of the stack.
-
-dup , which copies the top of the stack, can be easily implemented using
-temporary storage locations.  Conveniently, FIRST leaves memory locations 3, 4,
-and 5 unused.  So we can implement dup by writing the top of stack into 3, and
-then reading it out twice: { 3 ! 3 @ 3 @ }.
-
-We will never use the FIRST primitive 'pick' in building THIRD, just to show
-that it can be done; 'pick' is only provided because pick itself cannot be
-built out of the rest of FIRST's building blocks.
-
-So, instead of worrying about stack primitives and the like, what else is
-missing from FIRST?  We get recursion, but no control flow--no conditional
-operations.  We cannot at the moment write a looping routine which terminates.
-
-Another glaring dissimilarity between FIRST and FORTH is that there is no
-"command mode"--you cannot be outside of a : definition and issue some straight
-commands to be executed. Also, as we noted above, we cannot do comments.
-
-FORTH also provides a system for defining new data types, using the words [in
-one version of FORTH] <builds and does> . We would like to implement these
-words as well.
-
-As the highest priority thing, we will build control flow structures first.
-Once we have control structures, we can write recursive routines that
-terminate, and we are ready to tackle tasks like parsing, and the building of a
-command mode.
-
-By the way, location 0 holds the dictionary pointer, location 1 holds the
-return stack pointer, and location 2 should always be 0--it's a fake dictionary
-entry that means "pushint".
-
-Section 3: see third.go
-
-*/
+// Command gothird runs the THIRD kernel on top of a FIRST VM, followed by any
+// -e expressions and named script files; a "-" file argument, or no arguments
+// at all, reads stdin.
+//
+// Usage:
+//
+//	gothird [flags] [-e EXPR]... [FILE|-]...
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jcorbin/gothird/internal/logio"
+	"github.com/jcorbin/gothird/vm"
 )
 
 func main() {
 	var (
 		memLimit uint
 		timeout  time.Duration
+		maxSteps uint64
 		trace    bool
 		dump     bool
+		stats    bool
+		debug    bool
+		repl     bool
+		extended bool
+		include  bool
+		except   bool
+		divMod   bool
+		exprs    exprFlag
+		watches  watchFlag
 	)
 	flag.UintVar(&memLimit, "mem-limit", 0, "enable memory limit")
 	flag.DurationVar(&timeout, "timeout", 0, "specify a time limit")
+	flag.Uint64Var(&maxSteps, "max-steps", 0, "specify a limit on VM instructions executed, including those spent booting the kernel")
 	flag.BoolVar(&trace, "trace", false, "enable trace logging")
 	flag.BoolVar(&dump, "dump", false, "print a dump after execution")
+	flag.BoolVar(&stats, "stats", false, "print memory usage statistics after execution, e.g. to size -mem-limit")
+	flag.BoolVar(&debug, "debug", false, "run under an interactive debugger console, read from the controlling terminal")
+	flag.BoolVar(&repl, "repl", false, "run an interactive line-editing REPL on stdin, after any -e expressions and file arguments")
+	flag.BoolVar(&extended, "extended", false, "use extended builtins, and a THIRD kernel variant that relies on them")
+	flag.BoolVar(&include, "include", false, "add an include word, that reads a file named by the next token")
+	flag.BoolVar(&except, "exceptions", false, "add catch and throw words, that trap errors rather than halting")
+	flag.BoolVar(&divMod, "divmod", false, "add a /mod word, and redefine the THIRD kernel's mod with it")
+	flag.Var(&watches, "watch", "log every load from, or store to, memory cell ADDR; may be repeated")
+	flag.Var(&exprs, "e", "run an inline THIRD expression, before any file arguments; may be repeated")
 	flag.Parse()
 
 	log := logio.Logger{}
@@ -102,19 +68,64 @@ func main() {
 	}
 	in.WriteString("\n[\n")
 
-	vm := New(
-		WithLogf(log.Leveledf("TRACE")),
-		WithMemLimit(memLimit),
-		WithInputWriter(thirdKernel),
-		WithInput(&in),
-		WithInput(os.Stdin),
-		WithOutput(os.Stdout),
+	args := flag.Args()
+	if len(exprs) == 0 && len(args) == 0 && !repl {
+		args = []string{"-"}
+	}
+	inputs, err := openInputs(exprs, args)
+	if err != nil {
+		log.ErrorIf(err)
+		return
+	}
+	defer inputs.Close()
+
+	var ri *replInput
+	if repl {
+		var restore func()
+		ri, restore = newREPLInput(os.Stdin, os.Stdout)
+		defer restore()
+		inputs.opts = append(inputs.opts, vm.WithInput(ri), vm.WithRecovery(ri.recovered))
+	}
+
+	var kernel io.WriterTo = vm.ThirdKernel
+	var builtins vm.VMOption
+	if extended {
+		kernel = vm.ThirdExtendedKernel
+		builtins = vm.WithExtendedBuiltins()
+	}
+	if include {
+		builtins = vm.VMOptions(builtins, vm.WithInclude(nil))
+	}
+	if except {
+		builtins = vm.VMOptions(builtins, vm.WithExceptions())
+	}
+	var libs vm.VMOption
+	if divMod {
+		builtins = vm.VMOptions(builtins, vm.WithDivMod())
+		libs = vm.WithInputWriter(vm.ThirdDivMod)
+	}
+
+	machine := vm.New(
+		vm.WithLogf(log.Leveledf("TRACE")),
+		vm.WithMemLimit(memLimit),
+		vm.WithStepLimit(maxSteps),
+		builtins,
+		vm.WithInputWriter(kernel),
+		libs,
+		vm.WithInput(&in),
+		inputs.Option(),
+		vm.WithOutput(os.Stdout),
+		watches.Option(log.Leveledf("WATCH")),
 	)
 
 	if dump {
 		lw := &logio.Writer{Logf: log.Leveledf("DUMP")}
 		defer lw.Close()
-		defer vmDumper{vm: vm, out: lw}.dump()
+		defer machine.Dump(lw)
+	}
+
+	if stats {
+		defer func() { log.Leveledf("STATS")("%v", machine.Stats()) }()
 	}
 
 	if trace {
@@ -133,7 +144,26 @@ func main() {
 		defer cancel()
 	}
 
-	log.ErrorIf(vm.Run(ctx))
+	if ri != nil {
+		ri.vm = machine
+	}
+
+	if debug {
+		tty, err := os.Open("/dev/tty")
+		if err != nil {
+			log.ErrorIf(err)
+			return
+		}
+		defer tty.Close()
+		log.ErrorIf(debugger{
+			vm:  machine,
+			in:  bufio.NewScanner(tty),
+			out: os.Stderr,
+		}.run(ctx))
+		return
+	}
+
+	log.ErrorIf(machine.Run(ctx))
 }
 
 var scanPattern = regexp.MustCompile(`> scan (.+:\d+) .* <- .*`)
@@ -194,3 +224,90 @@ type namedBuffer struct {
 }
 
 func (nb namedBuffer) Name() string { return nb.name }
+
+// exprFlag collects repeated -e expressions.
+type exprFlag []string
+
+func (ef exprFlag) String() string      { return strings.Join(ef, " ") }
+func (ef *exprFlag) Set(s string) error { *ef = append(*ef, s); return nil }
+
+// watchFlag collects repeated -watch addresses.
+type watchFlag []uint
+
+func (wf watchFlag) String() string { return fmt.Sprint([]uint(wf)) }
+
+func (wf *watchFlag) Set(s string) error {
+	addr, err := strconv.ParseUint(s, 0, 0)
+	if err == nil {
+		*wf = append(*wf, uint(addr))
+	}
+	return err
+}
+
+// Option returns a VM option that watches each address, logging hits to logf.
+func (wf watchFlag) Option(logf func(mess string, args ...interface{})) vm.VMOption {
+	opts := make([]vm.VMOption, len(wf))
+	for i, addr := range wf {
+		opts[i] = vm.WithWatch(addr, 1, func(w vm.Watch) { logf("%v", w) })
+	}
+	return vm.VMOptions(opts...)
+}
+
+// inputFiles collects VM input options for -e expressions and file arguments,
+// along with any files that need closing.
+type inputFiles struct {
+	opts  []vm.VMOption
+	files []*os.File
+}
+
+func openInputs(exprs []string, args []string) (inputs inputFiles, err error) {
+	for i, expr := range exprs {
+		var nb namedBuffer
+		nb.name = fmt.Sprintf("<-e #%v>", i+1)
+		nb.WriteString(expr)
+		nb.WriteString("\n")
+		inputs.opts = append(inputs.opts, vm.WithInput(&nb))
+	}
+
+	for _, arg := range args {
+		if arg == "-" {
+			inputs.opts = append(inputs.opts, vm.WithInput(os.Stdin))
+			continue
+		}
+		f, err := os.Open(arg)
+		if err != nil {
+			inputs.Close()
+			return inputFiles{}, err
+		}
+		inputs.files = append(inputs.files, f)
+		inputs.opts = append(inputs.opts, vm.WithInputFile(arg, skipShebang(f)))
+	}
+
+	return inputs, nil
+}
+
+func (inputs inputFiles) Close() {
+	for _, f := range inputs.files {
+		f.Close()
+	}
+}
+
+func (inputs inputFiles) Option() vm.VMOption { return vm.VMOptions(inputs.opts...) }
+
+// skipShebang blanks out any leading "#!" line, so that scripts may be
+// directly executable, while preserving line numbering.
+func skipShebang(f *os.File) io.Reader {
+	br := bufio.NewReader(f)
+	if b, _ := br.Peek(2); string(b) == "#!" {
+		br.ReadString('\n')
+		return namedReader{io.MultiReader(strings.NewReader("\n"), br), f.Name()}
+	}
+	return namedReader{br, f.Name()}
+}
+
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func (nr namedReader) Name() string { return nr.name }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/jcorbin/gothird/internal/lineedit"
+	"github.com/jcorbin/gothird/vm"
+)
+
+// replInput feeds the VM one edited line at a time, prompting whenever the
+// VM needs more input.
+type replInput struct {
+	vm   *vm.VM
+	ed   *lineedit.Editor
+	out  io.Writer
+	rest strings.Reader
+}
+
+func (ri *replInput) Name() string { return "<repl>" }
+
+func (ri *replInput) Read(p []byte) (int, error) {
+	if err := ri.fill(); err != nil {
+		return 0, err
+	}
+	return ri.rest.Read(p)
+}
+
+func (ri *replInput) ReadRune() (rune, int, error) {
+	if err := ri.fill(); err != nil {
+		return 0, 0, err
+	}
+	return ri.rest.ReadRune()
+}
+
+func (ri *replInput) fill() error {
+	for ri.rest.Len() == 0 {
+		line, err := ri.ed.ReadLine(ri.prompt())
+		if err == lineedit.ErrInterrupt {
+			continue
+		} else if err != nil {
+			return err
+		}
+		ri.rest.Reset(line + "\n")
+	}
+	return nil
+}
+
+// prompt returns "... " while the VM is compiling a definition, and "> "
+// otherwise, e.g. while THIRD's command mode is waiting for input.
+func (ri *replInput) prompt() string {
+	if ri.vm.Compiling() {
+		return "... "
+	}
+	return "> "
+}
+
+// discard drops the remainder of the current line, e.g. after an error.
+func (ri *replInput) discard() { ri.rest.Reset("") }
+
+// complete returns all dictionary names.
+func (ri *replInput) complete(prefix string) []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range ri.vm.Words() {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// recovered reports an error recovered from by the VM, discarding the rest
+// of the line that caused it.
+func (ri *replInput) recovered(err error) {
+	fmt.Fprintf(ri.out, "error: %v\n", err)
+	ri.discard()
+}
+
+// newREPLInput creates REPL input from tty, which is put into non-canonical
+// non-echoing mode, without signals, so that Ctrl-C reaches the line editor
+// rather than killing the process; the returned function restores it.
+// If tty is not a terminal, lines are read without any editing.
+func newREPLInput(tty *os.File, out io.Writer) (*replInput, func()) {
+	ed := lineedit.NewEditor(bufio.NewReader(tty), out)
+	restore := func() {}
+	if info, err := tty.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		if state, err := stty(tty, "-g"); err == nil {
+			if _, err := stty(tty, "-icanon", "-echo", "-isig", "min", "1"); err == nil {
+				restore = func() { stty(tty, strings.TrimSpace(state)) }
+			}
+		}
+	} else {
+		// without a terminal there's nothing to edit, nor any need to echo
+		ed.Out = ioutil.Discard
+	}
+	ri := &replInput{ed: ed, out: out}
+	ed.Complete = ri.complete
+	return ri, restore
+}
+
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jcorbin/gothird/internal/lineedit"
+	"github.com/jcorbin/gothird/vm"
+)
+
+func Test_replInput(t *testing.T) {
+	var echo, out strings.Builder
+	ri := &replInput{
+		ed: lineedit.NewEditor(strings.NewReader(strings.Join([]string{
+			"bogus\x03: f 1 if", // Ctrl-C abandons the line, prompting again
+			"2 then ;",
+			"[",
+			"5 ,",
+			"f printnum",
+		}, "\n")+"\n"), &echo),
+		out: &out,
+	}
+	ri.vm = vm.New(
+		vm.WithInputWriter(vm.ThirdKernel),
+		vm.WithInput(ri),
+		vm.WithOutput(&out),
+	)
+	require.NoError(t, ri.vm.Run(context.Background()))
+	assert.Equal(t, "2", out.String(), "expected output")
+
+	var prompts []string
+	for _, line := range strings.Split(echo.String(), "\n") {
+		if i := strings.Index(line, "\x1b[K"); strings.HasPrefix(line, "\r") && i >= 0 {
+			prompts = append(prompts, line[1:i])
+		}
+	}
+	assert.Equal(t, []string{
+		"> ", "> ", // before and after Ctrl-C
+		"... ", // within f, after if
+		"> ", "> ", "> ",
+		"> ", // at EOF
+	}, prompts, "expected prompts")
+}
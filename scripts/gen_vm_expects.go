@@ -24,9 +24,12 @@ type namedReader interface {
 var (
 	in  namedReader    = os.Stdin
 	out io.WriteCloser = os.Stdout
+	pkg                = "vm"
+	gen                = "../scripts/gen_vm_expects.go"
 )
 
 func parseFlags() {
+	flag.StringVar(&pkg, "package", pkg, "package name for the generated file")
 	flag.Parse()
 
 	args := flag.Args()
@@ -111,14 +114,18 @@ var expectMethod = regexp.MustCompile(`func \(vmt vmTestCase\) (expect|with)(.+?
 func run(ctx context.Context) error {
 	var buf bytes.Buffer
 	buf.Grow(1024)
-	buf.WriteString("package main\n\n")
+	buf.WriteString("package ")
+	buf.WriteString(pkg)
+	buf.WriteString("\n\n")
 
 	buf.WriteString("// @generated from ")
 	buf.WriteString(in.Name())
 	buf.WriteString("\n\n")
 
 	if args := flag.Args(); len(args) >= 2 {
-		buf.WriteString("//go:generate go run scripts/gen_vm_expects.go --")
+		buf.WriteString("//go:generate go run ")
+		buf.WriteString(gen)
+		buf.WriteString(" --")
 		for _, arg := range args {
 			buf.WriteByte(' ')
 			buf.WriteString(arg)
@@ -0,0 +1,505 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/jcorbin/gothird/internal/fileinput"
+	"github.com/jcorbin/gothird/internal/flushio"
+	"github.com/jcorbin/gothird/internal/mem"
+	"github.com/jcorbin/gothird/internal/panicerr"
+)
+
+func New(opts ...VMOption) *VM {
+	var vm VM
+	defaultOptions().apply(&vm)
+	VMOptions(opts...).apply(&vm)
+	return &vm
+}
+
+// Run runs the VM until it halts, runs out of input, or ctx is done.
+// A VM isn't safe for concurrent use: while it runs, its other methods may
+// only be called by the host primitives that it calls, see WithPrimitive.
+func (vm *VM) Run(ctx context.Context) error {
+	err := panicerr.Recover("VM", func() error {
+		return vm.run(ctx)
+	})
+	if err == nil || errors.Is(err, io.EOF) {
+		return nil
+	}
+	var he haltError
+	if errors.As(err, &he) {
+		err = vm.haltedError(he.error)
+	}
+	return err
+}
+
+// Reset recovers a halted VM, so that it may Run again: the data stack is
+// cleared, the return stack is unwound, any partially compiled definition is
+// discarded, and the main loop is restarted, re-entering THIRD's command mode
+// if a "[" word is defined. The rest of memory is left as is.
+func (vm *VM) Reset() {
+	vm.guard(func() error {
+		vm.rollback()
+		return nil
+	})
+}
+
+// Compiling returns true while the latest definition is still incomplete, e.g.
+// while THIRD is waiting for input to finish compiling it, rather than in its
+// command mode; such a definition is discarded by Reset, or recovery.
+func (vm *VM) Compiling() (compiling bool) {
+	vm.guard(func() error {
+		compiling = vm.compiling()
+		return nil
+	})
+	return compiling
+}
+
+// Fork returns a new VM that shares vm's memory copy-on-write, so that it
+// may resume from the same state, e.g. a booted kernel, without paying to
+// recompile its dictionary; see also SaveImage.
+//
+// The fork gets a copy of the data stack, and a symbol overlay, so that it may
+// run concurrently with vm, and any other fork. Input, output, logging,
+// recovery, watches, breakpoints and source maps are not inherited; they must
+// be supplied anew by opts, as to New.
+func (vm *VM) Fork(opts ...VMOption) *VM {
+	fork := &VM{
+		prog:        vm.prog,
+		last:        vm.last,
+		stack:       vm.Stack(),
+		stackLimit:  vm.stackLimit,
+		divMode:     vm.divMode,
+		extended:    vm.extended,
+		symbols:     vm.symbols.fork(),
+		mem:         vm.mem.Fork(),
+		seal:        vm.seal,
+		builtinsEnd: vm.builtinsEnd,
+		maxH:        vm.maxH,
+		maxR:        vm.maxR,
+		prims:       vm.prims[:len(vm.prims):len(vm.prims)],
+		booted:      vm.booted,
+		stepLimit:   vm.stepLimit,
+
+		compactEvery:   vm.compactEvery,
+		compactedPages: vm.compactedPages,
+	}
+	defaultOptions().apply(fork)
+	VMOptions(opts...).apply(fork)
+	return fork
+}
+
+// rollback implements Reset, and recovery under Run. The latest definition is
+// discarded if it is still incomplete, see defined.
+func (vm *VM) rollback() {
+	compiling := vm.booted && vm.compiling()
+	vm.stack = vm.stack[:0]
+	vm.catches = vm.catches[:0]
+	vm.halted = nil
+	if !vm.booted {
+		return
+	}
+	vm.stor(1, vm.load(10)-1)
+
+	if compiling {
+		vm.forget(vm.defining, vm.definingLast)
+	}
+	vm.defining = 0
+
+	vm.prog = vm.entry()
+	if word := vm.lookup("["); word != 0 && vm.load(word+2) == vmCodeRun {
+		vm.call(word + 2)
+	}
+}
+
+func (vm *VM) compiling() bool { return vm.defining != 0 && !vm.defined() }
+
+// forget discards word, and anything compiled after it, restoring last to the
+// word before it.
+func (vm *VM) forget(word, last uint) {
+	vm.logf("#", "forget @%v <- %v", word, vm.load(0))
+	vm.last = last
+	vm.clearIndex()
+	vm.srcmap.forget(word)
+	vm.stor(0, int(word))
+}
+
+// recoverable returns true if err may be recovered from under WithRecovery.
+func (vm *VM) recoverable(err error) bool {
+	var he haltError
+	if !errors.As(err, &he) || he.error == nil {
+		return false
+	}
+	var stepLimit StepLimitError
+	return !errors.Is(he.error, io.EOF) && !errors.As(he.error, &stepLimit)
+}
+
+// Location names a line within a named VM input, or a position within one.
+type Location = fileinput.Location
+
+// Span names a range of VM input, e.g. that of a scanned token.
+type Span = fileinput.Span
+
+// VMError wraps any error that halted a VM, along with a snapshot of VM state
+// at the time of the halt.
+type VMError struct {
+	Err      error
+	Location Location // input location of the last token scanned, or line read
+	Word     string   // name of the word containing Addr, if any
+	Addr     uint     // address of the instruction that was executing
+	Prog     uint     // program counter
+	Stack    []int    // data stack
+	RStack   []int    // return stack
+	Source   Location // input location that Addr was compiled from, see WithSourceMap
+}
+
+func (err VMError) Error() string {
+	if err.Word != "" && err.Source.Name != "" {
+		return fmt.Sprintf("%v: in word %v (from %v): %v", err.Location, err.Word, err.Source, err.Err)
+	}
+	if err.Word != "" {
+		return fmt.Sprintf("%v: in word %v: %v", err.Location, err.Word, err.Err)
+	}
+	return fmt.Sprintf("%v: %v", err.Location, err.Err)
+}
+
+func (err VMError) Unwrap() error { return err.Err }
+
+func (vm *VM) haltedError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	loc := vm.token.Start
+	if loc.Name == "" {
+		if loc = vm.Scan.Location; vm.Scan.Len() == 0 {
+			loc = vm.Last.Location
+		}
+	}
+	word, _ := vm.WordOf(vm.addr)
+	src, _ := vm.SourceOf(vm.addr)
+	return VMError{
+		Err:      err,
+		Location: loc,
+		Word:     word,
+		Addr:     vm.addr,
+		Prog:     vm.prog,
+		Stack:    vm.Stack(),
+		RStack:   vm.RStack(),
+		Source:   src,
+	}
+}
+
+// Dump writes a human readable dump of the VM's stack and memory to out.
+func (vm *VM) Dump(out io.Writer) {
+	vmDumper{vm: vm, out: out}.dump()
+}
+
+// Push pushes values onto the data stack; it is intended for use by host
+// primitives.
+func (vm *VM) Push(values ...int) {
+	for _, val := range values {
+		vm.push(val)
+	}
+}
+
+// Pop pops a value off of the data stack, halting the VM on underflow; it is
+// intended for use by host primitives.
+func (vm *VM) Pop() int { return vm.pop() }
+
+// Load returns the value stored in main memory at addr, halting the VM on error.
+func (vm *VM) Load(addr uint) int { return vm.load(addr) }
+
+// Stor stores values into main memory at addr, halting the VM on error.
+func (vm *VM) Stor(addr uint, values ...int) { vm.stor(addr, values...) }
+
+// Stats reports memory usage, e.g. to size WithMemLimit and WithMemLayout.
+type Stats struct {
+	Pages int    // number of allocated memory pages
+	Cells uint   // number of cells in all allocated pages
+	MaxH  uint   // high-water mark of the dictionary pointer, h in cell 0
+	MaxR  uint   // high-water mark of the return stack pointer, r in cell 1
+	Loads uint64 // number of memory loads
+	Stors uint64 // number of memory stores
+}
+
+func (stats Stats) String() string {
+	return fmt.Sprintf("%v pages, %v cells, h <= %v, r <= %v, %v loads, %v stors",
+		stats.Pages, stats.Cells, stats.MaxH, stats.MaxR, stats.Loads, stats.Stors)
+}
+
+// Stats returns memory usage statistics.
+func (vm *VM) Stats() Stats {
+	ms := vm.mem.Stats()
+	stats := Stats{
+		Pages: ms.Pages,
+		Cells: ms.Cells,
+		MaxH:  vm.maxH,
+		MaxR:  vm.maxR,
+		Loads: ms.Loads,
+		Stors: ms.Stors,
+	}
+	for _, cache := range vm.caches() {
+		loads, stors := cache.Stats()
+		stats.Loads += loads
+		stats.Stors += stors
+	}
+	return stats
+}
+
+// Compact coalesces contiguous memory pages now, rather than waiting for
+// WithCompaction to, e.g. before a Fork that many VMs will share.
+func (vm *VM) Compact() { vm.compact() }
+
+// Steps returns the number of instructions executed so far.
+func (vm *VM) Steps() uint64 { return vm.steps }
+
+// Halt stops the VM, causing Run to return err; a nil err halts normally.
+func (vm *VM) Halt(err error) { vm.halt(err) }
+
+func WithInput(r io.Reader) VMOption              { return withInput(r) }
+func WithInputWriter(w io.WriterTo) VMOption      { return withInputWriter(w) }
+func WithOutput(w io.Writer) VMOption             { return withOutput(w) }
+func WithTee(w io.Writer) VMOption                { return withTee(w) }
+func WithMemLimit(limit uint) VMOption            { return withMemLimit(limit) }
+func WithMemLayout(retBase, memBase int) VMOption { return withMemLayout(retBase, memBase) }
+
+// WithStepLimit limits the VM to executing n instructions, after which it
+// halts with a StepLimitError; 0 means no limit.
+func WithStepLimit(n uint64) VMOption { return stepLimitOption(n) }
+
+// WithStackLimit limits the data stack to n values, past which the VM halts
+// with a StackOverflowError; 0 means no limit.
+func WithStackLimit(n int) VMOption { return stackLimitOption(n) }
+
+// WithRecovery enables error recovery under Run: rather than returning, any
+// error that halts the VM is passed to onError, after which the VM is Reset
+// and continues reading input. Running out of input, or the step limit,
+// still ends Run.
+func WithRecovery(onError func(err error)) VMOption { return recoveryOption(onError) }
+
+func WithLogf(logfn func(mess string, args ...interface{})) VMOption { return withLogfn(logfn) }
+
+// WithPrimitive adds a host primitive to the VM: a builtin word named name,
+// compiled after FIRST's builtins, that runs fn when executed.
+func WithPrimitive(name string, fn func(vm *VM)) VMOption { return primitiveOption{name, fn, false} }
+
+// WithExtendedBuiltins compiles extended builtins, like "+" and "dup", after
+// FIRST's own; see ThirdExtendedKernel for a THIRD kernel that uses them.
+func WithExtendedBuiltins() VMOption { return extendedOption{} }
+
+// WithSeal protects the memory layout cells 10 and 11, and FIRST's builtin
+// dictionary entries, from writes once the VM has booted; any such write then
+// halts the VM with a ProtectionError.
+func WithSeal() VMOption { return sealOption{} }
+
+// WithWatch calls fn on every load from, or store to, the n memory cells
+// starting at addr, e.g. to find which word corrupts h or r; see VM.Watch.
+func WithWatch(addr, n uint, fn func(w Watch)) VMOption { return watchOption{addr, n, fn} }
+
+// ProtectionError is the error when a VM writes to sealed memory, see WithSeal.
+type ProtectionError = mem.ProtectionError
+
+// WithCompaction compacts memory whenever a word is defined after n pages have
+// been allocated since the last compaction, coalescing contiguous pages so
+// that long running VMs keep a small page table; 0 means never.
+func WithCompaction(n int) VMOption { return compactionOption(n) }
+
+// WithDivisionMode sets how division rounds, TruncatedDivision by default.
+func WithDivisionMode(mode DivisionMode) VMOption { return divisionModeOption(mode) }
+
+// WithDivMod adds a "/mod" primitive, that divides pushing both remainder and
+// quotient; unlike THIRD's mod, it costs a single division, see ThirdDivMod.
+func WithDivMod() VMOption { return primitiveOption{"/mod", (*VM).divMod, false} }
+
+// WithExceptions adds the catch and throw primitives, see ThrowCode.
+func WithExceptions() VMOption {
+	return VMOptions(
+		primitiveOption{"catch", (*VM).catch, false},
+		primitiveOption{"throw", (*VM).throw, false},
+	)
+}
+
+type VMOption interface{ apply(vm *VM) }
+
+// defaultOptions returns fresh options for each VM, so that concurrently
+// running VMs share no state.
+func defaultOptions() VMOption {
+	return VMOptions(
+		withInput(bytes.NewReader(nil)),
+		withOutput(ioutil.Discard),
+	)
+}
+
+func VMOptions(opts ...VMOption) VMOption {
+	var res options
+	for _, opt := range opts {
+		switch impl := opt.(type) {
+		case nil, noption:
+		case options:
+			res = append(res, impl...)
+		default:
+			res = append(res, opt)
+		}
+	}
+	switch len(res) {
+	case 0:
+		return noption{}
+	case 1:
+		return res[0]
+	default:
+		return res
+	}
+}
+
+type noption struct{}
+
+func (noption) apply(vm *VM) {}
+
+type options []VMOption
+
+func (opts options) apply(vm *VM) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt.apply(vm)
+		}
+	}
+}
+
+type withLogfn func(mess string, args ...interface{})
+
+func (logfn withLogfn) apply(vm *VM) {
+	vm.logfn = logfn
+}
+
+type inputOption struct{ io.Reader }
+type outputOption struct{ io.Writer }
+type teeOption struct{ io.Writer }
+type memLimitOption uint
+type stepLimitOption uint64
+type stackLimitOption int
+type recoveryOption func(err error)
+type divisionModeOption DivisionMode
+type extendedOption struct{}
+type sealOption struct{}
+type compactionOption int
+type watchOption struct {
+	addr, n uint
+	fn      func(w Watch)
+}
+
+func withInput(r io.Reader) inputOption      { return inputOption{r} }
+func withOutput(w io.Writer) outputOption    { return outputOption{w} }
+func withTee(w io.Writer) teeOption          { return teeOption{w} }
+func withMemLimit(limit uint) memLimitOption { return memLimitOption(limit) }
+
+func withInputWriter(wto io.WriterTo) pipeInput {
+	r, w := io.Pipe()
+	go func() {
+		defer w.Close()
+		wto.WriteTo(w)
+	}()
+	return pipeInput{r, nameOf(wto)}
+}
+
+func nameOf(obj interface{}) string {
+	if nom, ok := obj.(interface{ Name() string }); ok {
+		return nom.Name()
+	}
+	return fmt.Sprintf("<unnamed %T>", obj)
+}
+
+func (i inputOption) apply(vm *VM) {
+	vm.Queue = append(vm.Queue, i.Reader)
+}
+
+func (o outputOption) apply(vm *VM) {
+	if vm.out != nil {
+		vm.out.Flush()
+	}
+	vm.out = flushio.NewWriteFlusher(o.Writer)
+	if cl, ok := o.Writer.(io.Closer); ok {
+		vm.closers = append(vm.closers, cl)
+	}
+}
+
+func (o teeOption) apply(vm *VM) {
+	vm.out = flushio.WriteFlushers(vm.out, flushio.NewWriteFlusher(o.Writer))
+	if cl, ok := o.Writer.(io.Closer); ok {
+		vm.closers = append(vm.closers, cl)
+	}
+}
+
+func (lim memLimitOption) apply(vm *VM) {
+	vm.mem.Limit = uint(lim)
+}
+
+func (lim stepLimitOption) apply(vm *VM) {
+	vm.stepLimit = uint64(lim)
+}
+
+func (lim stackLimitOption) apply(vm *VM) {
+	vm.stackLimit = int(lim)
+}
+
+func (n compactionOption) apply(vm *VM) {
+	vm.compactEvery = int(n)
+}
+
+func (sealOption) apply(vm *VM) {
+	vm.seal = true
+}
+
+func (w watchOption) apply(vm *VM) {
+	vm.Watch(w.addr, w.n, w.fn)
+}
+
+func (extendedOption) apply(vm *VM) {
+	vm.extended = true
+}
+
+func (mode divisionModeOption) apply(vm *VM) {
+	vm.divMode = DivisionMode(mode)
+}
+
+func (onError recoveryOption) apply(vm *VM) {
+	vm.onError = onError
+}
+
+type memLayoutOption struct {
+	retBase int
+	memBase int
+}
+
+func withMemLayout(retBase, memBase int) memLayoutOption { return memLayoutOption{retBase, memBase} }
+
+func (lay memLayoutOption) apply(vm *VM) {
+	if lay.retBase != 0 {
+		vm.stor(10, lay.retBase)
+	}
+	if lay.memBase != 0 {
+		vm.stor(11, lay.memBase)
+	}
+}
+
+type primitiveOption primitive
+
+func (prim primitiveOption) apply(vm *VM) {
+	vm.prims = append(vm.prims, primitive(prim))
+}
+
+type pipeInput struct {
+	*io.PipeReader
+	name string
+}
+
+func (pi pipeInput) Name() string { return pi.name }
+
+func (pi pipeInput) apply(vm *VM) {
+	vm.Queue = append(vm.Queue, pi)
+	vm.closers = append(vm.closers, pi)
+}
@@ -0,0 +1,145 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func BenchmarkKernelBoot(b *testing.B) {
+	for _, bc := range []struct {
+		name   string
+		kernel io.WriterTo
+		opts   []VMOption
+	}{
+		{"third", ThirdKernel, nil},
+		{"extended", ThirdExtendedKernel, []VMOption{WithExtendedBuiltins()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				vm := New(append(bc.opts, WithInputWriter(bc.kernel))...)
+				if err := vm.Run(context.Background()); err != nil {
+					b.Fatalf("kernel boot failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFork(b *testing.B) {
+	base := New(WithInputWriter(ThirdKernel))
+	if err := base.Run(context.Background()); err != nil {
+		b.Fatalf("kernel boot failed: %v", err)
+	}
+	for i := 0; i < b.N; i++ {
+		vm := base.Fork(WithInput(strings.NewReader(": test immediate 7 7 * . ; test")))
+		if err := vm.Run(context.Background()); err != nil {
+			b.Fatalf("fork run failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkLookup(b *testing.B) {
+	const numWords = 1000
+	vm := New(WithInput(strings.NewReader(testBuiltins)))
+	if err := vm.Run(context.Background()); err != nil {
+		b.Fatalf("builtins failed: %v", err)
+	}
+	if err := vm.guard(func() error {
+		for i := 0; i < numWords; i++ {
+			vm.compileHeader(vm.symbolicate(fmt.Sprintf("word%v", i)))
+			vm.compile(vmCodeExit)
+		}
+		return nil
+	}); err != nil {
+		b.Fatalf("dictionary setup failed: %v", err)
+	}
+
+	for _, name := range []string{
+		fmt.Sprintf("word%v", numWords-1), // latest
+		fmt.Sprintf("word%v", numWords/2),
+		"word0",
+		"exit", // oldest
+		"nope", // missing symbol
+	} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				vm.lookup(name)
+			}
+		})
+	}
+}
+
+// kernelImage returns an image of a VM that has just loaded the THIRD kernel,
+// so that benchmarks needn't pay to compile it every iteration.
+func kernelImage(b *testing.B) []byte {
+	var image bytes.Buffer
+	vm := New(WithInputWriter(ThirdKernel))
+	if err := vm.Run(context.Background()); err != nil {
+		b.Fatalf("kernel boot failed: %v", err)
+	}
+	if err := vm.SaveImage(&image); err != nil {
+		b.Fatalf("image save failed: %v", err)
+	}
+	return image.Bytes()
+}
+
+func benchmarkThird(b *testing.B, input, output string) {
+	image := kernelImage(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var out strings.Builder
+		vm := New(
+			WithImage(bytes.NewReader(image)),
+			WithInput(strings.NewReader(input)),
+			WithOutput(&out),
+		)
+		if err := vm.Run(context.Background()); err != nil {
+			b.Fatalf("run failed: %v", err)
+		}
+		if out.String() != output {
+			b.Fatalf("unexpected output %q", out.String())
+		}
+	}
+}
+
+func BenchmarkThird_doLoop(b *testing.B) {
+	benchmarkThird(b,
+		": test immediate 11 1 do i . loop nl ; test",
+		"1 2 3 4 5 6 7 8 9 10 \n")
+}
+
+func BenchmarkThird_countLoop(b *testing.B) {
+	benchmarkThird(b,
+		": test immediate 0 1001 1 do i + loop . ; test",
+		"500500 ")
+}
+
+func BenchmarkThird_recursion(b *testing.B) {
+	benchmarkThird(b, `
+		: fib dup 2 < if exit then dup 1 - fib swap 2 - fib + ;
+		: test immediate 15 fib . ;
+		test
+	`, "610 ")
+}
+
+func BenchmarkThird_doLoopTraced(b *testing.B) {
+	// tracing is off, but a log function is set, as under the command
+	image := kernelImage(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm := New(
+			WithImage(bytes.NewReader(image)),
+			WithLogf(func(string, ...interface{}) {}),
+			WithInput(strings.NewReader(": test immediate 11 1 do i . loop nl ; test")),
+			WithOutput(ioutil.Discard),
+		)
+		if err := vm.Run(context.Background()); err != nil {
+			b.Fatalf("run failed: %v", err)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package vm
 
 import (
 	"fmt"
@@ -116,6 +116,10 @@ func (log logging) logf(mark, mess string, args ...interface{}) {
 type symbols struct {
 	strings []string
 	symbols map[string]uint
+
+	// shared symbol maps, frozen by fork, innermost last; symbols then only
+	// holds those symbolicated since
+	shared []map[string]uint
 }
 
 func (sym symbols) string(id uint) string {
@@ -126,12 +130,20 @@ func (sym symbols) string(id uint) string {
 }
 
 func (sym symbols) symbol(s string) uint {
-	return sym.symbols[s]
+	if id, defined := sym.symbols[s]; defined || len(sym.shared) == 0 {
+		return id
+	}
+	for i := len(sym.shared) - 1; i >= 0; i-- {
+		if id, defined := sym.shared[i][s]; defined {
+			return id
+		}
+	}
+	return 0
 }
 
 func (sym *symbols) symbolicate(s string) (id uint) {
-	id, defined := sym.symbols[s]
-	if !defined {
+	id = sym.symbol(s)
+	if id == 0 {
 		if sym.symbols == nil {
 			sym.symbols = make(map[string]uint)
 		}
@@ -141,3 +153,17 @@ func (sym *symbols) symbolicate(s string) (id uint) {
 	}
 	return id
 }
+
+// fork returns an overlay of sym that shares all of its symbols, freezing
+// them so that both may then symbolicate new ones independently.
+func (sym *symbols) fork() symbols {
+	if len(sym.symbols) > 0 {
+		sym.shared = append(sym.shared[:len(sym.shared):len(sym.shared)], sym.symbols)
+		sym.symbols = nil
+	}
+	n, m := len(sym.strings), len(sym.shared)
+	return symbols{
+		strings: sym.strings[:n:n],
+		shared:  sym.shared[:m:m],
+	}
+}
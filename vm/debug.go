@@ -0,0 +1,267 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jcorbin/gothird/internal/panicerr"
+)
+
+// ErrHalted is returned by the stepping API once the VM has halted normally,
+// i.e. by returning from its outermost word, or by running out of input; the
+// latter is wrapped so that it still matches io.EOF under errors.Is.
+var ErrHalted = errors.New("VM halted")
+
+type inputHalted struct{ error }
+
+func (err inputHalted) Error() string        { return fmt.Sprintf("%v: %v", ErrHalted, err.error) }
+func (err inputHalted) Unwrap() error        { return err.error }
+func (err inputHalted) Is(target error) bool { return target == ErrHalted }
+
+// Step executes a single instruction, booting the VM first if necessary.
+// Once the VM has halted, Step and friends keep returning its halt error.
+func (vm *VM) Step() error {
+	return vm.debug(func() error {
+		vm.step()
+		return nil
+	})
+}
+
+// StepOver executes a single instruction, and then continues until any word
+// that it called or read returns, a breakpoint is hit, or ctx is done.
+func (vm *VM) StepOver(ctx context.Context) error {
+	return vm.debug(func() error {
+		r := vm.load(1)
+		vm.step()
+		for vm.load(1) > r && !vm.atBreak() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			vm.step()
+		}
+		return nil
+	})
+}
+
+// StepOut continues until the current word returns, a breakpoint is hit, or
+// ctx is done.
+func (vm *VM) StepOut(ctx context.Context) error {
+	return vm.debug(func() error {
+		r := vm.load(1)
+		vm.step()
+		for vm.load(1) >= r && !vm.atBreak() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			vm.step()
+		}
+		return nil
+	})
+}
+
+// Continue runs the VM until a breakpoint is hit, it halts, or ctx is done.
+// Continuing from a breakpoint steps past it first.
+func (vm *VM) Continue(ctx context.Context) error {
+	return vm.debug(func() error {
+		vm.step()
+		for !vm.atBreak() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			vm.step()
+		}
+		return nil
+	})
+}
+
+// Break sets a breakpoint at addr, which stops any Continue, StepOver, or
+// StepOut when the program counter reaches it.
+func (vm *VM) Break(addr uint) {
+	if vm.breaks == nil {
+		vm.breaks = make(map[uint]struct{})
+	}
+	vm.breaks[addr] = struct{}{}
+}
+
+// BreakWord sets a breakpoint at the start of the latest dictionary word
+// with the given name, returning the breakpoint address.
+// Immediate words break when read, all others break when called.
+func (vm *VM) BreakWord(name string) (addr uint, err error) {
+	err = vm.guard(func() error {
+		word := vm.lookup(name)
+		if word == 0 {
+			return fmt.Errorf("no such word %q", name)
+		}
+		if addr = word + 2; vm.load(addr) != vmCodeRun {
+			addr += 2 // skip compile and run time codes
+		}
+		return nil
+	})
+	if err == nil {
+		vm.Break(addr)
+	}
+	return addr, err
+}
+
+// ClearBreak removes any breakpoint at addr.
+func (vm *VM) ClearBreak(addr uint) { delete(vm.breaks, addr) }
+
+// Breakpoints returns all breakpoint addresses in ascending order.
+func (vm *VM) Breakpoints() []uint {
+	addrs := make([]uint, 0, len(vm.breaks))
+	for addr := range vm.breaks {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// Watch describes an access to watched memory, see WithWatch.
+type Watch struct {
+	Op   string // "load" or "stor"
+	Addr uint   // memory address accessed
+	Old  int    // value before the access
+	New  int    // value after the access, the same as Old for a load
+	Prog uint   // address of the instruction that was executing
+	Word string // name of the word containing Prog, if any
+}
+
+func (w Watch) String() string {
+	if w.Word != "" {
+		return fmt.Sprintf("%v @%v %v -> %v in %v @%v", w.Op, w.Addr, w.Old, w.New, w.Word, w.Prog)
+	}
+	return fmt.Sprintf("%v @%v %v -> %v @%v", w.Op, w.Addr, w.Old, w.New, w.Prog)
+}
+
+// Watch calls fn on every load from, or store to, the n memory cells starting
+// at addr; hits are also logged when tracing.
+// Accesses made while fn runs, e.g. to resolve Watch.Word, are not reported.
+func (vm *VM) Watch(addr, n uint, fn func(w Watch)) {
+	vm.mem.Watch(addr, addr+n, func(op string, addr uint, old, new int) {
+		if vm.watching {
+			return
+		}
+		vm.watching = true
+		defer func() { vm.watching = false }()
+		w := Watch{Op: op, Addr: addr, Old: old, New: new, Prog: vm.addr}
+		w.Word, _ = vm.wordOf(w.Prog)
+		vm.logf("@", "watch %v", w)
+		if fn != nil {
+			fn(w)
+		}
+	})
+}
+
+// Prog returns the current program counter.
+func (vm *VM) Prog() uint { return vm.prog }
+
+// Stack returns a copy of the data stack.
+func (vm *VM) Stack() []int { return append(make([]int, 0, len(vm.stack)), vm.stack...) }
+
+// RStack returns a copy of the return stack, or nil if it is corrupt.
+func (vm *VM) RStack() (rstack []int) {
+	vm.guard(func() error {
+		rstack = vm.rstack()
+		return nil
+	})
+	return rstack
+}
+
+// WordOf returns the name of the dictionary word containing addr, and the
+// offset of addr from the word's start; name is empty if addr isn't in a word.
+func (vm *VM) WordOf(addr uint) (name string, offset uint) {
+	vm.guard(func() error {
+		name, offset = vm.wordOf(addr)
+		return nil
+	})
+	return name, offset
+}
+
+// CodeName returns a name for the next instruction to be executed.
+func (vm *VM) CodeName() (name string) {
+	vm.guard(func() error {
+		name = vm.codeName()
+		return nil
+	})
+	return name
+}
+
+func (vm *VM) atBreak() bool {
+	_, hit := vm.breaks[vm.prog]
+	return hit
+}
+
+func (vm *VM) debug(f func() error) error {
+	if vm.halted != nil {
+		return vm.halted
+	}
+	err := vm.guard(func() error {
+		vm.boot()
+		return f()
+	})
+	var he haltError
+	if errors.As(err, &he) {
+		if err = vm.haltedError(he.error); err == nil {
+			err = ErrHalted
+		} else if errors.Is(err, io.EOF) {
+			err = inputHalted{err}
+		}
+		vm.halted = err
+	}
+	return err
+}
+
+func (vm *VM) guard(f func() error) error {
+	return panicerr.Recover("VM", f)
+}
+
+// ReadMem returns up to n values from memory starting at addr, stopping at
+// the end of allocated memory.
+func (vm *VM) ReadMem(addr uint, n int) ([]int, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid memory read of %v values", n)
+	}
+	if size := vm.mem.Size(); addr >= size {
+		n = 0
+	} else if uint(n) > size-addr {
+		n = int(size - addr)
+	}
+	buf := make([]int, n)
+	if err := vm.mem.LoadInto(addr, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Disasm formats the instruction at addr, returning it along with the address
+// of the following instruction.
+func (vm *VM) Disasm(addr uint) (code string, next uint) {
+	var buf bytes.Buffer
+	next = addr
+	vm.guard(func() error {
+		dump := vmDumper{vm: vm}
+		dump.scanWords()
+		next = dump.formatCode(&buf, addr)
+		return nil
+	})
+	return buf.String(), next
+}
+
+// Words returns the names of all dictionary words, latest first.
+func (vm *VM) Words() (names []string) {
+	vm.guard(func() error {
+		for word := vm.last; word != 0; word = uint(vm.load(word)) {
+			if sym := uint(vm.load(word + 1)); sym == 0 {
+				names = append(names, "ø")
+			} else {
+				names = append(names, vm.string(sym))
+			}
+		}
+		return nil
+	})
+	return names
+}
@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_debug(t *testing.T) {
+	ctx := context.Background()
+	vm := New(WithInput(strings.NewReader(testBuiltins + `
+		: sq 0 pick * exit
+		: test immediate 3 sq 4 sq exit
+		test
+	`)))
+
+	_, err := vm.BreakWord("sq")
+	require.Error(t, err, "expected no sq word before boot")
+
+	// step until sq is defined, which breaks at its body from then on
+	var sqBody uint
+	for err != nil {
+		require.NoError(t, vm.Step(), "must step until sq is defined")
+		sqBody, err = vm.BreakWord("sq")
+	}
+	require.NoError(t, vm.Continue(ctx), "must continue to sq")
+	assert.Equal(t, sqBody, vm.Prog(), "expected to stop in sq")
+	assert.Equal(t, []int{3}, vm.Stack(), "expected sq argument")
+	name, offset := vm.WordOf(vm.Prog())
+	assert.Equal(t, "sq", name, "expected word name")
+	assert.Equal(t, uint(4), offset, "expected word offset")
+	assert.Equal(t, "pushint(0)", vm.CodeName(), "expected code name")
+
+	addr, err := vm.BreakWord("sq")
+	require.NoError(t, err, "must break on sq")
+	assert.Equal(t, sqBody, addr, "expected sq breakpoint address")
+	assert.Equal(t, []uint{sqBody}, vm.Breakpoints(), "expected breakpoints")
+
+	require.NoError(t, vm.Step(), "must step")
+	assert.Equal(t, []int{3, 0}, vm.Stack(), "expected pushint")
+
+	rstack := vm.RStack()
+	require.NoError(t, vm.StepOut(ctx), "must step out of sq")
+	assert.Equal(t, []int{9}, vm.Stack(), "expected sq result")
+	assert.Equal(t, len(rstack)-1, len(vm.RStack()), "expected return stack to unwind")
+	name, _ = vm.WordOf(vm.Prog())
+	assert.Equal(t, "test", name, "expected to return into test")
+
+	require.NoError(t, vm.StepOver(ctx), "must step over pushint")
+	require.NoError(t, vm.StepOver(ctx), "must stop at sq breakpoint")
+	assert.Equal(t, sqBody, vm.Prog(), "expected to stop in sq again")
+	require.NoError(t, vm.StepOut(ctx), "must step out of sq")
+	assert.Equal(t, []int{9, 16}, vm.Stack(), "expected second sq result")
+
+	values, err := vm.ReadMem(sqBody, 2)
+	require.NoError(t, err, "must read memory")
+	assert.Equal(t, []int{vmCodePushint, 0}, values, "expected sq body")
+	_, err = vm.ReadMem(sqBody, -1)
+	assert.Error(t, err, "expected negative read error")
+	values, err = vm.ReadMem(sqBody, 1<<30)
+	require.NoError(t, err, "must read memory")
+	assert.Less(t, len(values), 1<<20, "expected read to stop at the end of memory")
+
+	vm.ClearBreak(sqBody)
+	assert.Equal(t, []uint{}, vm.Breakpoints(), "expected no breakpoints")
+
+	err = vm.Continue(ctx)
+	assert.True(t, errors.Is(err, ErrHalted), "expected to halt, got %v", err)
+	assert.True(t, errors.Is(err, io.EOF), "expected to run out of input, got %v", err)
+	assert.Equal(t, err, vm.Step(), "expected to stay halted")
+
+	done, cancel := context.WithCancel(ctx)
+	cancel()
+	for _, step := range []func(context.Context) error{
+		New(WithInput(strings.NewReader(testBuiltins + ": loop loop exit loop\n"))).StepOver,
+		New(WithInput(strings.NewReader(testBuiltins + ": loop loop exit loop\n"))).StepOut,
+	} {
+		assert.Equal(t, context.Canceled, step(done), "expected to stop when ctx is done")
+	}
+}
+
+func Test_watch(t *testing.T) {
+	type hit struct {
+		op       string
+		addr     uint
+		old, new int
+		word     string
+	}
+	var hits []hit
+	var out bytes.Buffer
+	vm := New(
+		WithInputWriter(ThirdKernel),
+		WithInput(namedString{"test", strings.NewReader(`
+			: test immediate 42 5000 ! 5000 @ . ;
+			test
+		`)}),
+		WithOutput(&out),
+		WithWatch(5000, 1, func(w Watch) {
+			hits = append(hits, hit{w.Op, w.Addr, w.Old, w.New, w.Word})
+		}),
+	)
+	require.NoError(t, vm.Run(context.Background()))
+	assert.Equal(t, "42 ", out.String())
+	assert.Equal(t, []hit{
+		{"stor", 5000, 0, 42, "test"},
+		{"load", 5000, 42, 42, "test"},
+	}, hits)
+}
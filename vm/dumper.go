@@ -1,6 +1,7 @@
-package main
+package vm
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"sort"
@@ -34,6 +35,7 @@ func (dump vmDumper) dump() {
 	fmt.Fprintf(dump.out, "  dict: %v\n", dump.words)
 
 	dump.dumpStack()
+	fmt.Fprintf(dump.out, "  stats: %v\n", dump.vm.Stats())
 	dump.dumpMem()
 }
 
@@ -145,6 +147,11 @@ func (dump *vmDumper) formatMem(buf fmtBuf, addr uint) uint {
 			break
 		}
 
+		if src, ok := dump.vm.SourceOf(word); ok {
+			buf.WriteString(" # ")
+			buf.WriteString(src.String())
+		}
+
 		if dump.rawWords {
 			code := make([]int, addr-word)
 			dump.vm.loadInto(word, code)
@@ -167,8 +174,8 @@ func (dump *vmDumper) formatCode(buf fmtBuf, addr uint) uint {
 	addr++
 
 	// builtin code
-	if code < vmCodeMax {
-		buf.WriteString(vmCodeNames[code])
+	if prim, ok := dump.vm.primitive(code); ok {
+		buf.WriteString(prim.name)
 		if code == vmCodePushint {
 			buf.WriteByte('(')
 			buf.WriteString(strconv.Itoa(dump.vm.load(addr)))
@@ -229,3 +236,12 @@ func (dump *vmDumper) nextWord() uint {
 	}
 	return dump.word()
 }
+
+type lineBuffer struct{ bytes.Buffer }
+
+func (buf *lineBuffer) WriteTo(w io.Writer) (n int64, err error) {
+	if b := buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	return buf.Buffer.WriteTo(w)
+}
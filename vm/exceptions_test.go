@@ -0,0 +1,53 @@
+package vm
+
+import (
+	"testing"
+)
+
+func Test_exceptions(t *testing.T) {
+	const defs = `
+		: good 7 ;
+		: bad 1 0 / ;
+		: thrower 42 throw 99 ;
+		: fiddle fromr tor good ;
+	`
+	vmTestCases{
+		vmTest("caught").withOptions(WithExceptions()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", defs+`
+				: test immediate
+					' good catch . .
+					3 ' bad catch . .
+					' thrower catch .
+					' fiddle catch . .
+					0 throw
+					nl ;
+				test
+			`).
+			expectOutput("0 7 -10 3 42 0 7 \n"),
+
+		vmTest("caught primitive").withOptions(WithExceptions()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", `
+				: test immediate 7 0 ' / catch . ;
+				test
+			`).
+			expectOutput("-10 "),
+
+		vmTest("caught throw").withOptions(WithExceptions()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", `
+				: test immediate 5 ' throw catch . . ;
+				test
+			`).
+			expectOutput("5 0 "),
+
+		vmTest("uncaught").withOptions(WithExceptions()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", defs+`
+				: test immediate thrower ;
+				test
+			`).
+			expectError(ThrowError(42)),
+	}.run(t)
+}
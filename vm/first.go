@@ -0,0 +1,1358 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/jcorbin/gothird/internal/mem"
+	"github.com/jcorbin/gothird/internal/runeio"
+)
+
+//// Section 1:  FIRST
+
+//// Environment
+
+// FIRST implements a virtual machine.  The machine has three chunks of memory:
+// "main memory", "the stack", and "string storage".  When the virtual machine
+// wishes to do random memory accesses, they come out of main memory--it cannot
+// access the stack or string storage.
+type VM struct {
+	Core
+
+	prog uint // program counter
+	last uint // last word
+	addr uint // address of the currently executing instruction
+
+	token  Span      // input span of the last token scanned
+	srcmap sourceMap // optional source locations of compiled cells
+
+	// The stack is simply a standard LIFO data structure that is used
+	// implicitly by most of the FIRST primitives.  The stack is made up of
+	// ints, whatever size they are on the host machine.
+	stack      []int
+	stackLimit int
+
+	divMode  DivisionMode
+	extended bool // compile extended builtins, see WithExtendedBuiltins
+
+	// String storage is used to store the names of built-in and defined
+	// primitives.  Separate storage is used for these because it allows the Go
+	// code to use Go string operations, reducing Go source code size.
+	symbols
+	words map[uint]uint // index of latest words by name, see lookup
+
+	// Main memory is a large array of ints.  When we speak of addresses, we
+	// actually mean indices into main memory.  Main memory is used for two
+	// things, primarily: the return stack and the dictionary.
+	mem mem.Ints
+
+	// Page caches speed up the VM's most frequent memory accesses: registers
+	// and memory layout in low memory, flags, the return stack, and the program.
+	regs, conf, flags, rets, progs mem.IntsCache
+
+	// high-water marks of h and r, see Stats
+	maxH, maxR uint
+
+	// compact memory whenever compactEvery pages have been allocated since
+	// it last had compactedPages, see WithCompaction
+	compactEvery   int
+	compactedPages int
+
+	// Sealing protects memory layout and builtins from writes after boot.
+	seal bool
+
+	// Host primitives extend the code table past vmCodeMax; each one gets a
+	// builtin dictionary entry compiled right after FIRST's own.
+	prims []primitive
+
+	// An image to restore, rather than compiling builtins, see WithImage;
+	// builtinsEnd is where the builtins, whether compiled or restored, end.
+	image       io.Reader
+	builtinsEnd uint
+
+	// names of inputs read from files, see WithInputFile
+	fileNames map[string]bool
+
+	booted bool
+
+	// defining is the latest word begun by define, until read finds it
+	// defined; definingLast is the word before it, and definingDepth the data
+	// stack depth when it began; literalEnd is the address just past the latest
+	// literal compiled by read; onError, if set, enables recovery under Run
+	defining      uint
+	definingLast  uint
+	definingDepth int
+	literalEnd    uint
+	onError       func(err error)
+
+	// steps counts executed instructions, halting past any non-zero stepLimit
+	steps     uint64
+	stepLimit uint64
+
+	// active catch frames, innermost last, see WithExceptions
+	catches []catchFrame
+
+	// debugger state, see Step and Continue
+	breaks   map[uint]struct{}
+	halted   error
+	watching bool // true while calling a watch function, see Watch
+}
+
+// The return stack is a LIFO data structure, independent of the
+// above-mentioned "the stack", which is used by FIRST to keep track of
+// function call return addresses.
+func (vm *VM) call(addr uint) { vm.pushr(vm.prog); vm.prog = addr }
+
+// The dictionary is a list of words.  Each word contains a header and a data
+// field.  In the header is the address of the previous word, an index into the
+// string storage indicating where the name of this word is stored, and a "code
+// pointer".  The code pointer is simply an integer which names which
+// "machine-language-primitive" implements this instruction.  For example, for
+// defined words the code pointer names the "run some code" primitive, which
+// pushes the current program counter onto the return stack and sets the
+// counter to the address of the data field for this word.
+
+// There are several important pointers into main memory.  There is a pointer
+// to the most recently defined word, which is used to start searches back
+// through memory when compiling words.  There is a pointer to the top of the
+// return stack.  There is a pointer to the current end of the dictionary, used
+// while compiling.
+
+// For the last two pointers, namely the return stack pointer and the
+// dictionary pointer, there is an important distinction: the pointers
+// themselves are stored in main memory (in FIRST's main memory).  This is
+// critical, because it means FIRST programs can get at them without any
+// further primitives needing to be defined.
+
+//// Instructions
+
+// There are two kinds of FIRST instructions, normal instructions and immediate
+// instructions.  Immediate instructions do something significant when they are
+// used.  Normal instructions compile a pointer to their executable part onto
+// the end of the dictionary.  As we will see, this means that by default FIRST
+// simply compiles things.
+
+//// Integer Operations
+
+// Symbol   Name           Function
+//    -     binary minus   pop top 2 elements of stack, subtract, push
+func (vm *VM) sub() { b, a := vm.pop(), vm.pop(); vm.push(a - b) }
+
+// Symbol   Name           Function
+//    *     multiply       pop top 2 elements of stack, multiply, push
+func (vm *VM) mul() { b, a := vm.pop(), vm.pop(); vm.push(a * b) }
+
+// Symbol   Name           Function
+//    /     divide         pop top 2 elements of stack, divide, push
+func (vm *VM) div() {
+	b, a := vm.pop(), vm.pop()
+	q, _ := vm.divmod(a, b)
+	vm.push(q)
+}
+
+// Symbol   Name           Function
+//   /mod   divide modulo  pop top 2 elements of stack, divide, push the
+//                         remainder and then the quotient; added by WithDivMod
+func (vm *VM) divMod() {
+	b, a := vm.pop(), vm.pop()
+	q, r := vm.divmod(a, b)
+	vm.push(r)
+	vm.push(q)
+}
+
+// DivisionMode selects how division rounds non-exact quotients.
+type DivisionMode uint8
+
+const (
+	// TruncatedDivision rounds quotients toward zero, so that any remainder
+	// has the sign of the dividend; this is the default, as in Go.
+	TruncatedDivision DivisionMode = iota
+
+	// FlooredDivision rounds quotients toward negative infinity, so that any
+	// remainder has the sign of the divisor.
+	FlooredDivision
+)
+
+func (vm *VM) divmod(a, b int) (q, r int) {
+	if b == 0 {
+		vm.halt(DivideByZeroError{vm.stackError()})
+	}
+	q, r = a/b, a%b
+	if vm.divMode == FlooredDivision && r != 0 && (r < 0) != (b < 0) {
+		q--
+		r += b
+	}
+	return q, r
+}
+
+//// Extended Operations
+
+// FIRST's builtins are minimal; THIRD synthesizes arithmetic and stack
+// shuffling from them at the cost of several steps each.  These extended
+// builtins provide the most common such words directly.
+
+func (vm *VM) add()    { b, a := vm.pop(), vm.pop(); vm.push(a + b) }
+func (vm *VM) dup()    { a := vm.pop(); vm.push(a); vm.push(a) }
+func (vm *VM) drop()   { vm.pop() }
+func (vm *VM) swap()   { b, a := vm.pop(), vm.pop(); vm.push(b); vm.push(a) }
+func (vm *VM) over()   { b, a := vm.pop(), vm.pop(); vm.push(a); vm.push(b); vm.push(a) }
+func (vm *VM) rot()    { c, b, a := vm.pop(), vm.pop(), vm.pop(); vm.push(b); vm.push(c); vm.push(a) }
+func (vm *VM) and()    { b, a := vm.pop(), vm.pop(); vm.push(a & b) }
+func (vm *VM) or()     { b, a := vm.pop(), vm.pop(); vm.push(a | b) }
+func (vm *VM) xor()    { b, a := vm.pop(), vm.pop(); vm.push(a ^ b) }
+func (vm *VM) lshift() { b, a := vm.pop(), vm.pop(); vm.push(a << uint(b)) }
+func (vm *VM) rshift() { b, a := vm.pop(), vm.pop(); vm.push(int(uint(a) >> uint(b))) }
+func (vm *VM) eq()     { b, a := vm.pop(), vm.pop(); vm.push(boolInt(a == b)) }
+func (vm *VM) lt()     { b, a := vm.pop(), vm.pop(); vm.push(boolInt(a < b)) }
+
+// Symbol   Name           Function
+//   <0     less than 0    pop top element of stack, push 1 if < 0 else 0
+func (vm *VM) under0() { a := vm.pop(); vm.push(boolInt(a < 0)) }
+
+// Note that we can synthesize addition and negation from binary minus, but we
+// cannot synthesize a time efficient divide or multiply from it. <0 is
+// synthesizable, but only nonportably.
+
+//// Memory Operations
+
+// Symbol   Name    Function
+//   @      fetch   pop top of stack, treat as address to push contents of
+func (vm *VM) get() { addr := uint(vm.pop()); vm.push(vm.load(addr)) }
+
+// Symbol   Name    Function
+//   !      store   top of stack is address, 2nd is value; store to memory and
+//                  pop both off the stack
+func (vm *VM) set() { addr := uint(vm.pop()); vm.stor(addr, vm.pop()) }
+
+//// Input/Output Operations
+
+// Name    Function
+// echo    write top of stack to output as a rune
+func (vm *VM) echo() { vm.writeRune(rune(vm.pop())) }
+
+// Name    Function
+// key     read a rune from input onto top of stack
+func (vm *VM) key() { vm.push(int(vm.readRune())) }
+
+// Name    Function
+// _read   read a space-delimited word, find it in the dictionary, and compile
+//         a pointer to that word's code pointer onto the current end of the
+//         dictionary
+func (vm *VM) read() {
+	if vm.defining != 0 && vm.defined() {
+		vm.defining = 0 // so that later stores, e.g. by THIRD's ",", can't reopen it
+	}
+
+	token, _ := vm.scan()
+	if word := vm.lookup(token); word != 0 {
+		vm.logf(".", "read %v @%v", token, word)
+		vm.pushr(vm.prog)
+		vm.prog = word + 2
+		return
+	}
+
+	val := vm.literal(token)
+	vm.logf(".", "read pushint(%v)", val)
+	vm.compile(vmCodePushint)
+	vm.compile(int(val))
+	vm.literalEnd = uint(vm.load(0))
+}
+
+// Although _read could be synthesized from key, we need _read to be able to
+// compile words to be able to start any syntheses.
+
+//// Execution Operations
+
+// Name   Function
+// exit   leave the current function: pop the return stack
+//        into the program counter
+func (vm *VM) exit() { vm.prog = vm.popr() }
+
+//// Immediate (compilation) Operations
+
+// Symbol      Name        Function
+//    :        define      read in the next space-delimited word, add it to the
+//                         end of our string storage, and generate a header for
+//                         the new word so that when it is typed it compiles a
+//                         pointer to itself so that it can be executed.
+func (vm *VM) define() {
+	token, _ := vm.scan()
+	vm.logf(".", "define %v -> @%v", token, uint(vm.load(0)))
+	vm.defining, vm.definingLast, vm.definingDepth = uint(vm.load(0)), vm.last, len(vm.stack)
+	vm.compileHeader(vm.symbolicate(token))
+}
+
+// defined returns true once the latest definition is finished, i.e. once the
+// dictionary ends with an exit, rather than a literal's operand, and any
+// addresses pushed to compile control flow, like those of THIRD's "if" and
+// "else", have been popped, leaving the data stack as deep as it began.
+func (vm *VM) defined() bool {
+	h := uint(vm.load(0))
+	return vm.load(h-1) == vmCodeExit && h != vm.literalEnd && len(vm.stack) == vm.definingDepth
+}
+
+// Symbol      Name        Function
+// immediate   immediate   when used immediately after a name following a ':',
+//                         makes the word being defined run whenever it is
+//                         typed.
+func (vm *VM) immediate() {
+	h := uint(vm.load(0))
+	h--                  // back
+	code := vm.load(h)   // read run time code
+	h--                  // back
+	vm.stor(h, code)     // overwrite compile time code
+	vm.stor(0, int(h+1)) // continue
+	vm.logf(".", "immediate @%v <- %v <- @%v", h-1, code, h)
+}
+
+// : cannot be synthesized, because we could not synthesize anything.
+// immediate has to be an immediate operation, so it could not be synthesized
+// unless by default operations were immediate; but that would preclude our
+// being able to do any useful compilation.
+
+//// Stack Operations
+
+// Name   Function
+// pick   pop top of stack, use as index into stack and copy up that element
+func (vm *VM) pick() {
+	i := vm.pop()
+	i = len(vm.stack) - 1 - i
+	if i < 0 || i >= len(vm.stack) {
+		vm.push(0)
+	} else {
+		vm.push(vm.stack[i])
+	}
+}
+
+// If the data stack were stored in main memory, we could synthesize pick; but
+// putting the stack and stack pointer in main memory would significantly
+// increase the C source code size.
+
+// There are three more primitives, but they are "internal only"-- they have no
+// names and no dictionary entries.  The first is "pushint".  It takes the next
+// integer out of the instruction stream and pushes it on the stack.  This
+// could be synthesized, but probably not without using integer constants.  It
+// is generated by _read when the input is not a known word.  The second is
+// "compile me".  When this instruction is executed, a pointer to the word's
+// data field is appended to the dictionary.  The third is "run me"--the word's
+// data field is taken to be a stream of pointers to words, and is executed.
+
+//// Internal primitives have no names and no dictionary entries.
+
+// The first is "pushint".
+// It takes the next integer out of the instruction stream and pushes it on the
+// stack.  It is generated by _read when the input is not a known word.
+func (vm *VM) pushint() { vm.push(vm.loadProg()) }
+
+// The second is "compile me".
+// When this instruction is executed, a pointer to the word's data field is
+// appended to the dictionary.
+func (vm *VM) compileme() {
+	addr := vm.prog
+	if vm.load(addr) == vmCodeRun {
+		addr++
+	}
+	vm.compile(int(addr))
+	vm.exit()
+}
+
+func (vm *VM) compileit() {
+	code := vm.loadProg()
+	for {
+		vm.compile(code)
+		next := vm.loadProg()
+		if code == vmCodeExit || next == vmCodeExit {
+			vm.exit()
+			return
+		}
+		code = next
+	}
+}
+
+// The third is "run me"--the word's data field is taken to be a stream of
+// pointers to words, and is executed.
+func (vm *VM) runme() {}
+
+// One last note about the environment: FIRST builds a very small word
+// internally that it executes as its main loop.  This word calls _read and
+// then calls itself.  Each time it calls itself, it uses up a word on the
+// return stack, so it will eventually trash things. This is discussed some
+// more in section 2.
+func (vm *VM) compileEntry() uint {
+	vm.compileHeader(0)
+	vm.immediate()
+	h := uint(vm.load(0))
+	vm.compile(vmCodeRead)
+	// vm.immediate() FIXME
+	vm.compile(int(h))
+	vm.compile(vmCodeExit)
+	return h
+}
+
+// entry returns the address of the main loop, compiled by compileEntry as
+// the first word in the dictionary.
+func (vm *VM) entry() uint {
+	word := vm.last
+	for word != 0 {
+		prev := uint(vm.load(word))
+		if prev == 0 {
+			break
+		}
+		word = prev
+	}
+	return word + 3
+}
+
+const (
+	// Here's a handy summary of all the FIRST words:
+	vmCodeExit      = iota // exit        stop running the current function
+	vmCodeDefine           // :           compile the header of a definition
+	vmCodeImmediate        // immediate   modify the header to create an immediate word
+	vmCodeRead             // _read       read a word from input and compile a pointer to it
+	vmCodeGet              // @           read from memory
+	vmCodeSet              // !           write to memory
+	vmCodeSub              // -           binary integer operation on the stack
+	vmCodeMul              // *           binary integer operation on the stack
+	vmCodeDiv              // /           binary integer operation on the stack
+	vmCodeLess             // <0          is top of stack less than 0?
+	vmCodeEcho             // echo        output one character
+	vmCodeKey              // key         input one character
+	vmCodePick             // pick        pop top of stack, use as index into stack and copy up that element
+
+	vmCodeCompile // <INTERNAL>  compile the program counter
+	vmCodeRun     // <INTERNAL>  run at the program counter
+	vmCodePushint // <INTERNAL>  push from memory at program counter
+	vmCodeCompIt  // <INTERNAL>  compile from memory at program counter
+
+	// Extended builtins, only compiled under WithExtendedBuiltins:
+	vmCodeAdd    // +           binary integer operation on the stack
+	vmCodeDup    // dup         duplicate the top of stack
+	vmCodeDrop   // drop        discard the top of stack
+	vmCodeSwap   // swap        swap the top two stack elements
+	vmCodeOver   // over        copy up the second stack element
+	vmCodeRot    // rot         rotate the third stack element to the top
+	vmCodeAnd    // and         binary bitwise operation on the stack
+	vmCodeOr     // or          binary bitwise operation on the stack
+	vmCodeXor    // xor         binary bitwise operation on the stack
+	vmCodeLshift // lshift      shift 2nd element left by top element bits
+	vmCodeRshift // rshift      shift 2nd element right, unsigned, by top element bits
+	vmCodeEq     // =           are the top two stack elements equal?
+	vmCodeLt     // <           is 2nd element less than top element?
+
+	vmCodeMax
+	vmCodeLastBuiltin = vmCodePick
+)
+
+// Unlike FIRST's builtins, extended builtins aren't named by input.
+var vmExtendedWords = [...]string{
+	"+", "dup", "drop", "swap", "over", "rot",
+	"and", "or", "xor", "lshift", "rshift",
+	"=", "<",
+}
+
+func (vm *VM) compileBuiltins() {
+	vm.define()
+	vm.stor(vm.last+2, vmCodeCompIt) // compile inline
+	vm.compile(vmCodeExit)
+	vm.immediate() // write the vmCodeExit token over the prior vmCodeRun
+
+	for code := vmCodeDefine; code <= vmCodeLastBuiltin; code++ {
+		vm.define()
+		vm.stor(vm.last+2, vmCodeCompIt) // compile inline
+		if code <= vmCodeImmediate {
+			vm.immediate()
+		}
+		vm.compile(code)
+		vm.immediate() // write the builtin token over the prior vmCodeRun
+		vm.compile(vmCodeExit)
+	}
+
+	// words named by the host, rather than by input, have no source
+	vm.token = Span{}
+	if vm.extended {
+		vm.compileExtended()
+	}
+	vm.compilePrimitives()
+}
+
+func (vm *VM) compileExtended() {
+	for i, name := range vmExtendedWords {
+		vm.compileHeader(vm.symbolicate(name))
+		vm.stor(vm.last+2, vmCodeCompIt) // compile inline
+		vm.compile(vmCodeAdd + i)
+		vm.immediate() // write the builtin token over the prior vmCodeRun
+		vm.compile(vmCodeExit)
+	}
+}
+
+// Host primitives are Go functions, named by the host rather than by input,
+// whose codes are allocated after vmCodeMax in the order that they were added.
+type primitive struct {
+	name      string
+	fn        func(vm *VM)
+	immediate bool
+}
+
+func (vm *VM) compilePrimitives() {
+	for i, prim := range vm.prims {
+		vm.logf(".", "primitive %v -> @%v", prim.name, uint(vm.load(0)))
+		vm.compileHeader(vm.symbolicate(prim.name))
+		vm.stor(vm.last+2, vmCodeCompIt) // compile inline
+		if prim.immediate {
+			vm.immediate()
+		}
+		vm.compile(vmCodeMax + i)
+		vm.immediate() // write the primitive token over the prior vmCodeRun
+		vm.compile(vmCodeExit)
+	}
+}
+
+func (vm *VM) primitive(code uint) (prim primitive, ok bool) {
+	if code < vmCodeMax {
+		return primitive{vmCodeNames[code], vmCodeTable[code], false}, true
+	}
+	if i := code - vmCodeMax; i < uint(len(vm.prims)) {
+		return vm.prims[i], true
+	}
+	return primitive{}, false
+}
+
+// Exceptions let THIRD programs trap errors, rather than halting the VM.
+// Errors that would otherwise halt the VM are thrown as negative codes, as
+// listed by ThrowCode; uncaught throws halt with a ThrowError.
+type catchFrame struct {
+	depth  int  // data stack depth after popping the execution token
+	rdepth uint // return stack depth
+	prog   uint // resume address
+}
+
+// retDepth returns the number of addresses on the return stack.
+func (vm *VM) retDepth() uint { return uint(vm.load(1)) - (uint(vm.load(10)) - 1) }
+
+// Symbol   Name           Function
+//   catch  catch          pop an execution token and run it, pushing 0 when it
+//                         returns normally; if anything it runs throws a
+//                         non-zero code instead, the data and return stacks
+//                         are restored, and the code is pushed; added by
+//                         WithExceptions
+func (vm *VM) catch() {
+	xt := uint(vm.pop())
+	vm.catches = append(vm.catches, catchFrame{len(vm.stack), vm.retDepth(), vm.prog})
+	if prim, ok := vm.primitive(xt); ok {
+		vm.catchPrimitive(prim)
+	} else {
+		vm.call(xt)
+	}
+}
+
+// catchPrimitive runs a primitive execution token inline, completing its catch
+// frame unless the primitive threw, or halted, to it.
+func (vm *VM) catchPrimitive(prim primitive) {
+	n := len(vm.catches)
+	defer vm.catchHalt()
+	prim.fn(vm)
+	if len(vm.catches) == n {
+		vm.catches = vm.catches[:n-1]
+		vm.push(0)
+	}
+}
+
+// Symbol   Name           Function
+//   throw  throw          pop a code, throwing it if non-zero; added by
+//                         WithExceptions
+func (vm *VM) throw() {
+	if code := vm.pop(); code != 0 {
+		if len(vm.catches) == 0 {
+			vm.halt(ThrowError(code))
+		}
+		vm.unwind(code)
+	}
+}
+
+// caught completes the innermost catch frame once its execution token has
+// returned to the resume address, at the return stack depth it was called
+// from, returning true if so; the return stack pointer alone doesn't suffice,
+// since words like THIRD's fromr move it while the token is still running.
+func (vm *VM) caught() bool {
+	frame := vm.catches[len(vm.catches)-1]
+	if vm.prog != frame.prog || vm.retDepth() != frame.rdepth {
+		return false
+	}
+	vm.catches = vm.catches[:len(vm.catches)-1]
+	vm.push(0)
+	return true
+}
+
+// catchHalt recovers any halt that maps to a throw code, unwinding to the
+// innermost catch frame.
+func (vm *VM) catchHalt() {
+	if e := recover(); e != nil {
+		if he, ok := e.(haltError); ok && len(vm.catches) > 0 {
+			if code := ThrowCode(he.error); code != 0 {
+				vm.logf("#", "throw %v: %v", code, he.error)
+				vm.unwind(code)
+				return
+			}
+		}
+		panic(e)
+	}
+}
+
+func (vm *VM) unwind(code int) {
+	frame := vm.catches[len(vm.catches)-1]
+	vm.catches = vm.catches[:len(vm.catches)-1]
+	for len(vm.stack) < frame.depth {
+		vm.stack = append(vm.stack, 0)
+	}
+	vm.stack = vm.stack[:frame.depth]
+	vm.stor(1, int(uint(vm.load(10))-1+frame.rdepth))
+	vm.prog = frame.prog
+	vm.push(code)
+}
+
+// Throw codes for errors raised by the VM itself, following ANS Forth.
+const (
+	ThrowStackOverflow     = -3
+	ThrowStackUnderflow    = -4
+	ThrowRetStackOverflow  = -5
+	ThrowRetStackUnderflow = -6
+	ThrowInvalidAddress    = -9
+	ThrowDivideByZero      = -10
+	ThrowUndefinedWord     = -13
+)
+
+// ThrowError is the error when a non-zero code is thrown, but not caught.
+type ThrowError int
+
+func (code ThrowError) Error() string { return fmt.Sprintf("uncaught throw %v", int(code)) }
+
+// ThrowCode returns the code that an error is thrown as, or 0 for errors that
+// cannot be caught, like running out of input.
+func ThrowCode(err error) int {
+	var (
+		thrown   ThrowError
+		limit    mem.LimitError
+		prot     mem.ProtectionError
+		retOver  retOverError
+		retUnder retUnderError
+		literal  literalError
+	)
+	switch {
+	case errors.As(err, &thrown):
+		return int(thrown)
+	case errors.Is(err, ErrStackOverflow):
+		return ThrowStackOverflow
+	case errors.Is(err, ErrStackUnderflow):
+		return ThrowStackUnderflow
+	case errors.As(err, &retOver):
+		return ThrowRetStackOverflow
+	case errors.As(err, &retUnder):
+		return ThrowRetStackUnderflow
+	case errors.As(err, &limit), errors.As(err, &prot):
+		return ThrowInvalidAddress
+	case errors.Is(err, ErrDivideByZero):
+		return ThrowDivideByZero
+	case errors.As(err, &literal):
+		return ThrowUndefinedWord
+	}
+	return 0
+}
+
+var vmCodeTable [vmCodeMax]func(vm *VM)
+var vmCodeNames [vmCodeMax]string
+
+func init() {
+	vmCodeTable = [...]func(vm *VM){
+		(*VM).exit,
+		(*VM).define,
+		(*VM).immediate,
+		(*VM).read,
+		(*VM).get,
+		(*VM).set,
+		(*VM).sub,
+		(*VM).mul,
+		(*VM).div,
+		(*VM).under0,
+		(*VM).echo,
+		(*VM).key,
+		(*VM).pick,
+
+		(*VM).compileme,
+		(*VM).runme,
+		(*VM).pushint,
+		(*VM).compileit,
+
+		(*VM).add,
+		(*VM).dup,
+		(*VM).drop,
+		(*VM).swap,
+		(*VM).over,
+		(*VM).rot,
+		(*VM).and,
+		(*VM).or,
+		(*VM).xor,
+		(*VM).lshift,
+		(*VM).rshift,
+		(*VM).eq,
+		(*VM).lt,
+	}
+
+	vmCodeNames = [...]string{
+		"exit",
+		"define",
+		"immediate",
+		"read",
+		"get",
+		"set",
+		"sub",
+		"mul",
+		"div",
+		"under0",
+		"echo",
+		"key",
+		"pick",
+
+		"compileme",
+		"runme",
+		"pushint",
+		"compileit",
+
+		"add",
+		"dup",
+		"drop",
+		"swap",
+		"over",
+		"rot",
+		"and",
+		"or",
+		"xor",
+		"lshift",
+		"rshift",
+		"eq",
+		"lt",
+	}
+}
+
+// Here is a sample FIRST program.  I'm assuming you're using the ASCII
+// character set.  FIRST does not depend upon ASCII, but since FIRST has no
+// syntax for character constants, one normally has to use decimal values. This
+// can be gotten around using getchar, though. Oh.  One other odd thing. FIRST
+// initially builds its symbol table by calling : several times, so it needs to
+// get the names of the base symbols as its first 13 words of input. You could
+// even name them differently if you wanted.
+
+// These FIRST programs have FORTH comments in them: they are contained inside
+// parentheses.  FIRST programs cannot have FORTH comments; but I need some
+// device to indicate what's going on.  (THIRD programs are an entirely
+// different subject.)
+
+//    ( Our first line gives the symbols for the built-ins )
+//    : immediate _read @ ! - * / <0 exit echo key pick
+//
+//    ( now we define a simple word that will print out a couple characters )
+//
+//    : L                ( define a word named 'L' )
+//      108 echo         ( output an ascii 'l' )
+//      exit
+//
+//    : hello            ( define a word named 'hello')
+//      72 echo          ( output an ascii 'H' )
+//      101 echo         ( output an ascii 'e' )
+//      111              ( push ascii 'o' onto the stack )
+//      L L              ( output two ascii 'l's )
+//      echo             ( output the 'o' we pushed on the stack before )
+//      10 echo          ( print a newline )
+//      exit             ( stop running this routine )
+//
+//    : test immediate   ( define a word named 'test' that runs whenever typed )
+//      hello            ( call hello )
+//      exit
+//
+//    test
+//
+//    ( The result of running this program should be:
+//    Hello
+//    )
+
+func (vm *VM) load(addr uint) int {
+	val, err := vm.mem.Load(addr)
+	if err != nil {
+		vm.halt(err)
+	}
+	return val
+}
+
+func (vm *VM) loadInto(addr uint, buf []int) {
+	if err := vm.mem.LoadInto(addr, buf); err != nil {
+		vm.halt(err)
+	}
+}
+
+func (vm *VM) stor(addr uint, values ...int) {
+	if err := vm.mem.Stor(addr, values...); err != nil {
+		vm.halt(err)
+	}
+	if addr <= 1 {
+		vm.markHigh(addr, values)
+	}
+}
+
+// markHigh updates the high-water marks of h and r after a store of values
+// at addr.
+func (vm *VM) markHigh(addr uint, values []int) {
+	for i, val := range values {
+		switch addr + uint(i) {
+		case 0:
+			if uint(val) > vm.maxH {
+				vm.maxH = uint(val)
+			}
+		case 1:
+			if uint(val) > vm.maxR {
+				vm.maxR = uint(val)
+			}
+		}
+	}
+}
+
+// cached loads addr through cache, falling back to a normal load.
+func (vm *VM) cached(cache *mem.IntsCache, addr uint) int {
+	if val, ok := cache.Load(addr); ok {
+		return val
+	}
+	return vm.loadMiss(cache, addr)
+}
+
+func (vm *VM) loadMiss(cache *mem.IntsCache, addr uint) int {
+	if cache.Fill(&vm.mem, addr) {
+		val, _ := cache.Load(addr)
+		return val
+	}
+	return vm.load(addr)
+}
+
+// storCached stores val at addr through cache, falling back to a normal store.
+func (vm *VM) storCached(cache *mem.IntsCache, addr uint, val int) {
+	if !cache.Stor(addr, val) {
+		vm.storMiss(cache, addr, val)
+	}
+}
+
+func (vm *VM) storMiss(cache *mem.IntsCache, addr uint, val int) {
+	if !cache.Fill(&vm.mem, addr) || !cache.Stor(addr, val) {
+		vm.stor(addr, val)
+	}
+}
+
+func (vm *VM) loadProg() int {
+	// FIXME conflicts with low tmp space needed by third's execute
+	// if memBase := uint(vm.load(11)); vm.prog < memBase {
+	// 	vm.halt(progError(vm.prog))
+	// }
+	val := vm.cached(&vm.progs, vm.prog)
+	vm.prog++
+	return val
+}
+
+func (vm *VM) push(val int) {
+	if vm.stackLimit != 0 && len(vm.stack) >= vm.stackLimit {
+		vm.halt(StackOverflowError{vm.stackError(), vm.stackLimit})
+	}
+	vm.stack = append(vm.stack, val)
+}
+
+func (vm *VM) pop() (val int) {
+	i := len(vm.stack) - 1
+	if i < 0 {
+		vm.halt(StackUnderflowError{vm.stackError()})
+	}
+	val, vm.stack = vm.stack[i], vm.stack[:i]
+	return val
+}
+
+func (vm *VM) pushr(addr uint) {
+	r := uint(vm.cached(&vm.regs, 1))
+	if retBase := uint(vm.cached(&vm.conf, 10)); r < retBase-1 {
+		vm.halt(retUnderError(r))
+	}
+	if memBase := uint(vm.cached(&vm.conf, 11)); r >= memBase-1 {
+		vm.halt(retOverError(r))
+	}
+	r++
+	vm.storCached(&vm.rets, r, int(addr))
+	vm.storCached(&vm.regs, 1, int(r))
+	if r > vm.maxR {
+		vm.maxR = r
+	}
+}
+
+func (vm *VM) popr() uint {
+	r := uint(vm.cached(&vm.regs, 1))
+	if retBase := uint(vm.cached(&vm.conf, 10)); r == retBase-1 {
+		vm.halt(nil)
+	} else if r < retBase-1 {
+		vm.halt(retUnderError(r))
+	} else if memBase := uint(vm.cached(&vm.conf, 11)); r > memBase-1 {
+		vm.halt(retOverError(r))
+	}
+	val := uint(vm.cached(&vm.rets, r))
+	vm.storCached(&vm.regs, 1, int(r-1))
+	return val
+}
+
+func (vm *VM) compile(val int) {
+	h := uint(vm.load(0))
+	end := h + 1
+	vm.stor(0, int(end))
+	vm.stor(h, val)
+	if vm.token.Start.Name != "" {
+		vm.srcmap.record(h, vm.token.Start)
+	}
+}
+
+func (vm *VM) compileHeader(name uint) {
+	h := uint(vm.load(0))
+	prev := vm.last
+	vm.compile(int(prev))
+	vm.compile(int(name))
+	vm.compile(vmCodeCompile) // compile time code
+	vm.compile(vmCodeRun)     // run time code
+	vm.last = h
+	if name != 0 {
+		vm.indexWord(name, h)
+	}
+	if vm.compactEvery != 0 {
+		vm.maybeCompact()
+	}
+}
+
+// maybeCompact compacts memory if enough pages have been allocated since the
+// last compaction, see WithCompaction.
+func (vm *VM) maybeCompact() {
+	if pages := vm.mem.Stats().Pages; pages >= vm.compactedPages+vm.compactEvery {
+		vm.compact()
+	}
+}
+
+func (vm *VM) compact() {
+	vm.mem.Compact()
+	vm.compactedPages = vm.mem.Stats().Pages
+}
+
+func (vm *VM) lookup(token string) uint {
+	name := vm.symbol(token)
+	if name == 0 {
+		return 0
+	}
+	if word, ok := vm.words[name]; ok && uint(vm.load(word+1)) == name {
+		return word
+	}
+	for word := vm.last; word != 0; word = uint(vm.load(word)) {
+		if sym := uint(vm.load(word + 1)); sym == name {
+			vm.indexWord(name, word)
+			return word
+		}
+	}
+	return 0
+}
+
+// The dictionary's linked list in memory is authoritative, but searching it
+// for every token read gets slow as it grows; so lookup also maintains an
+// index from each name to its latest word, which is updated by every new
+// definition, and cleared whenever words are forgotten.
+// Index entries are checked against the word's name in memory before use;
+// names that aren't found aren't indexed, since memory may yet define them.
+func (vm *VM) indexWord(name, word uint) {
+	if vm.words == nil {
+		vm.words = make(map[uint]uint)
+	}
+	vm.words[name] = word
+}
+
+func (vm *VM) clearIndex() { vm.words = nil }
+
+func (vm *VM) literal(token string) int {
+	if n, err := strconv.ParseInt(token, 0, strconv.IntSize); err == nil {
+		return int(n)
+	}
+	if value, err := runeio.UnquoteRune(token); err == nil {
+		return int(value)
+	}
+	vm.halt(literalError(token))
+	return 0
+}
+
+func (vm *VM) wordOf(addr uint) (string, uint) {
+	for prev := vm.last; prev != 0; {
+		if prev < addr {
+			if sym := uint(vm.load(prev + 1)); sym == 0 {
+				return "ø", addr - prev
+			} else if name := vm.string(sym); name != "" {
+				return name, addr - prev
+			}
+			break
+		}
+		prev = uint(vm.load(prev))
+	}
+	return "", 0
+}
+
+func (vm *VM) codeName() string {
+	code := uint(vm.loadProg())
+	defer func() { vm.prog-- }()
+	prim, ok := vm.primitive(code)
+	if !ok {
+		if name, _ := vm.wordOf(code); name != "" {
+			return name
+		}
+		return fmt.Sprintf("call(%v)", code)
+	}
+	if code == vmCodePushint {
+		return fmt.Sprintf("pushint(%v)", vm.load(vm.prog))
+	}
+	return prim.name
+}
+
+const (
+	debugTRON = 1 << iota
+)
+
+// checkFlag reports whether flag is set in the debug flags cell, just below
+// the return stack base.
+func (vm *VM) checkFlag(flag int) bool {
+	retBase, ok := vm.conf.Load(10)
+	if !ok {
+		if !vm.conf.Fill(&vm.mem, 10) {
+			return false
+		}
+		retBase, _ = vm.conf.Load(10)
+	}
+	addr := uint(retBase) - 1
+	val, ok := vm.flags.Load(addr)
+	if !ok && vm.flags.Fill(&vm.mem, addr) {
+		val, _ = vm.flags.Load(addr)
+	}
+	return val&flag != 0
+}
+
+func (vm *VM) logf(mark, message string, args ...interface{}) {
+	if vm.checkFlag(debugTRON) {
+		vm.logging.logf(mark, message, args...)
+	}
+}
+
+func (vm *VM) step() {
+	if len(vm.catches) > 0 {
+		if vm.caught() {
+			return
+		}
+		defer vm.catchHalt()
+	}
+
+	if vm.stepLimit != 0 && vm.steps >= vm.stepLimit {
+		vm.addr = vm.prog // so that any VMError agrees on the word
+		word, _ := vm.wordOf(vm.prog)
+		vm.halt(StepLimitError{vm.steps, vm.prog, word})
+	}
+	vm.steps++
+
+	if vm.logfn != nil && vm.checkFlag(debugTRON) {
+		vm.trace()
+	}
+
+	vm.addr = vm.prog
+	code := uint(vm.loadProg())
+	if code < vmCodeMax {
+		vmCodeTable[code](vm)
+	} else if prim, ok := vm.primitive(code); ok {
+		prim.fn(vm)
+	} else {
+		vm.call(code)
+	}
+}
+
+// trace logs the next instruction, along with the stacks; it's kept out of
+// step, so as not to burden it while tracing is off.
+func (vm *VM) trace() {
+	at := fmt.Sprintf(" @%v", vm.prog)
+
+	funcName, _ := vm.wordOf(vm.prog)
+	if vm.funcWidth < len(funcName) {
+		vm.funcWidth = len(funcName)
+	}
+
+	codeName := vm.codeName()
+	if vm.codeWidth < len(codeName) {
+		vm.codeWidth = len(codeName)
+	}
+
+	format := "% *v.% -*v s:%v r:%v"
+	args := []interface{}{
+		vm.funcWidth, funcName,
+		vm.codeWidth, codeName,
+		vm.stack,
+		vm.rstack(),
+	}
+	if src, ok := vm.SourceOf(vm.prog); ok {
+		format += " src:%v"
+		args = append(args, src)
+	}
+	vm.logging.logf(at, format, args...)
+}
+
+func (vm *VM) rstack() []int {
+	rb := uint(vm.load(10))
+	r := uint(vm.load(1))
+	if r < rb-1 {
+		vm.halt(retUnderError(r))
+	} else if r < rb {
+		return []int{}
+	}
+	rstack := make([]int, r-rb+1)
+	vm.loadInto(rb, rstack)
+	return rstack
+}
+
+const (
+	defaultPageSize = 256
+	defaultRetBase  = 1 // in pages
+	defaultMemBase  = 4 // in pages
+)
+
+func (vm *VM) init() {
+	pageSize := vm.mem.PageSize
+	if pageSize == 0 {
+		pageSize = defaultPageSize
+		vm.mem.PageSize = pageSize
+	}
+
+	retBase := uint(vm.load(10))
+	if retBase == 0 {
+		retBase = defaultRetBase * pageSize
+		vm.stor(10, int(retBase))
+	}
+
+	memBase := uint(vm.load(11))
+	if memBase == 0 {
+		memBase = defaultMemBase * pageSize
+		vm.stor(11, int(memBase))
+	}
+
+	if h := uint(vm.load(0)); h == 0 {
+		vm.stor(0, int(memBase))
+	}
+
+	if r := uint(vm.load(1)); r == 0 {
+		vm.stor(1, int(retBase-1))
+	} else if r < retBase-1 {
+		vm.halt(retUnderError(r))
+	} else if r > memBase {
+		vm.halt(retOverError(r))
+	}
+}
+
+func (vm *VM) run(ctx context.Context) error {
+	vm.boot()
+	if vm.onError == nil {
+		return vm.runSteps(ctx)
+	}
+	for {
+		err := vm.guard(func() error { return vm.runSteps(ctx) })
+		if !vm.recoverable(err) {
+			return err
+		}
+		var he haltError
+		errors.As(err, &he)
+		vm.onError(vm.haltedError(he.error))
+		vm.rollback()
+	}
+}
+
+func (vm *VM) runSteps(ctx context.Context) error {
+	done := ctx.Done()
+	for {
+		vm.step()
+		select {
+		case <-done:
+			return ctx.Err()
+		default:
+		}
+	}
+}
+
+func (vm *VM) boot() {
+	if vm.booted {
+		return
+	}
+	vm.booted = true
+
+	if vm.image != nil {
+		// resume from a saved image
+		vm.loadImage()
+		vm.init()
+	} else {
+		vm.init()
+
+		// clear program counter and compile builtins
+		vm.prog = 0
+		entry := vm.compileEntry()
+		vm.compileBuiltins()
+		vm.builtinsEnd = uint(vm.load(0))
+
+		// run the entry point
+		vm.prog = entry
+	}
+
+	if vm.seal {
+		vm.sealMem()
+	}
+}
+
+// sealMem protects the memory layout cells, and the dictionary entries of all
+// builtins, from any further writes; h, r, and the temporary cells in low
+// memory must remain writable for THIRD to work.
+func (vm *VM) sealMem() {
+	vm.mem.Protect(10, 12, mem.ReadOnly)
+	if memBase := uint(vm.load(11)); memBase < vm.builtinsEnd {
+		vm.mem.Protect(memBase, vm.builtinsEnd, mem.ReadOnly)
+	}
+}
+
+func (vm *VM) caches() []*mem.IntsCache {
+	return []*mem.IntsCache{&vm.regs, &vm.conf, &vm.flags, &vm.rets, &vm.progs}
+}
+
+func (vm *VM) scan() (token string, span Span) {
+	defer func() {
+		line := vm.Scan
+		if line.Len() == 0 {
+			line = vm.Last
+		}
+		vm.logf(">", "scan %v %q <- %q", line.Location, token, line.Buffer.String())
+	}()
+
+	if err := vm.out.Flush(); err != nil {
+		vm.halt(err)
+	}
+
+	var sb strings.Builder
+	for {
+		if r, _, err := vm.Core.ReadRune(); err != nil {
+			vm.halt(err)
+		} else if !unicode.IsControl(r) && !unicode.IsSpace(r) {
+			sb.WriteRune(r)
+			span.Start = vm.RunePos
+			span.End = vm.Pos
+			break
+		}
+	}
+	for {
+		r, _, err := vm.Core.ReadRune()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			vm.halt(err)
+		} else if unicode.IsControl(r) || unicode.IsSpace(r) {
+			break
+		} else {
+			sb.WriteRune(r)
+			span.End = vm.Pos
+		}
+	}
+	vm.token = span
+	return sb.String(), span
+}
+
+var (
+	// ErrStackUnderflow matches any StackUnderflowError under errors.Is.
+	ErrStackUnderflow = errors.New("stack underflow")
+
+	// ErrStackOverflow matches any StackOverflowError under errors.Is.
+	ErrStackOverflow = errors.New("stack overflow")
+
+	// ErrDivideByZero matches any DivideByZeroError under errors.Is.
+	ErrDivideByZero = errors.New("divide by zero")
+)
+
+// StackError carries the location and data stack contents of a stack error.
+type StackError struct {
+	Addr  uint   // address of the instruction that caused the error
+	Word  string // name of the word containing Addr, if any
+	Stack []int  // data stack contents when the error occurred
+}
+
+// StackUnderflowError is the error when popping an empty data stack.
+type StackUnderflowError struct{ StackError }
+
+// StackOverflowError is the error when pushing onto a full data stack, see
+// WithStackLimit.
+type StackOverflowError struct {
+	StackError
+	Limit int
+}
+
+func (vm *VM) stackError() StackError {
+	word, _ := vm.wordOf(vm.addr)
+	return StackError{vm.addr, word, append([]int(nil), vm.stack...)}
+}
+
+func (se StackError) String() string {
+	if se.Word != "" {
+		return fmt.Sprintf("@%v in %v s:%v", se.Addr, se.Word, se.Stack)
+	}
+	return fmt.Sprintf("@%v s:%v", se.Addr, se.Stack)
+}
+
+func (under StackUnderflowError) Error() string {
+	return fmt.Sprintf("%v %v", ErrStackUnderflow, under.StackError)
+}
+
+func (over StackOverflowError) Error() string {
+	return fmt.Sprintf("%v past %v %v", ErrStackOverflow, over.Limit, over.StackError)
+}
+
+// DivideByZeroError is the error when dividing by zero.
+type DivideByZeroError struct{ StackError }
+
+func (div DivideByZeroError) Error() string {
+	return fmt.Sprintf("%v %v", ErrDivideByZero, div.StackError)
+}
+
+func (StackUnderflowError) Unwrap() error { return ErrStackUnderflow }
+func (StackOverflowError) Unwrap() error  { return ErrStackOverflow }
+func (DivideByZeroError) Unwrap() error   { return ErrDivideByZero }
+
+// StepLimitError is the error when a VM exceeds its step limit, see WithStepLimit.
+type StepLimitError struct {
+	Steps uint64 // number of steps executed
+	Prog  uint   // program counter of the next step
+	Word  string // name of the word containing Prog, if any
+}
+
+func (lim StepLimitError) Error() string {
+	if lim.Word != "" {
+		return fmt.Sprintf("step limit exceeded after %v steps @%v in %v", lim.Steps, lim.Prog, lim.Word)
+	}
+	return fmt.Sprintf("step limit exceeded after %v steps @%v", lim.Steps, lim.Prog)
+}
+
+type progError uint
+type retOverError uint
+type retUnderError uint
+type literalError string
+
+func (r retOverError) Error() string   { return fmt.Sprintf("return stack overflow @%v", uint(r)) }
+func (r retUnderError) Error() string  { return fmt.Sprintf("return stack underflow @%v", uint(r)) }
+func (addr progError) Error() string   { return fmt.Sprintf("program smashed %v", uint(addr)) }
+func (lit literalError) Error() string { return fmt.Sprintf("invalid literal %q", string(lit)) }
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,459 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_VM(t *testing.T) {
+	var testCases vmTestCases
+
+	// primitive tests that work by driving individual VM methods
+	var (
+		compileme = (*VM).compileme
+		define    = (*VM).define
+		immediate = (*VM).immediate
+		read      = (*VM).read
+		get       = (*VM).get
+		set       = (*VM).set
+		sub       = (*VM).sub
+		mul       = (*VM).mul
+		div       = (*VM).div
+		divMod    = (*VM).divMod
+		add       = (*VM).add
+		dup       = (*VM).dup
+		drop      = (*VM).drop
+		swap      = (*VM).swap
+		over      = (*VM).over
+		rot       = (*VM).rot
+		and       = (*VM).and
+		or        = (*VM).or
+		xor       = (*VM).xor
+		lshift    = (*VM).lshift
+		rshift    = (*VM).rshift
+		eq        = (*VM).eq
+		lt        = (*VM).lt
+		under0    = (*VM).under0
+		exit      = (*VM).exit
+		echo      = (*VM).echo
+		key       = (*VM).key
+		pick      = (*VM).pick
+		pushint   = (*VM).pushint
+		step      = (*VM).step
+	)
+	testCases = append(testCases,
+		// binary integer operation on the stack
+		vmTest("sub").withStack(5, 3, 1).do(sub).expectStack(5, 2),
+		vmTest("div").withStack(7, 13, 3).do(div).expectStack(7, 4),
+		vmTest("div negative").withStack(-7, 2).do(div).expectStack(-3),
+		vmTest("div floored").withOptions(WithDivisionMode(FlooredDivision)).withStack(-7, 2).do(div).expectStack(-4),
+		vmTest("div by zero").withStack(7, 0).do(div).expectError(ErrDivideByZero),
+		vmTest("/mod").withStack(13, 3).do(divMod).expectStack(1, 4),
+		vmTest("/mod negative").withStack(-7, 2).do(divMod).expectStack(-1, -3),
+		vmTest("/mod floored").withOptions(WithDivisionMode(FlooredDivision)).withStack(-7, 2).do(divMod).expectStack(1, -4),
+		vmTest("/mod floored divisor").withOptions(WithDivisionMode(FlooredDivision)).withStack(7, -2).do(divMod).expectStack(-1, -4),
+		vmTest("/mod by zero").withStack(7, 0).do(divMod).expectError(ErrDivideByZero),
+		vmTest("mul").withStack(11, 5, 6).do(mul).expectStack(11, 30),
+
+		// extended builtins
+		vmTest("add").withStack(5, 3, 1).do(add).expectStack(5, 4),
+		vmTest("dup").withStack(5, 3).do(dup).expectStack(5, 3, 3),
+		vmTest("drop").withStack(5, 3).do(drop).expectStack(5),
+		vmTest("swap").withStack(5, 3, 1).do(swap).expectStack(5, 1, 3),
+		vmTest("over").withStack(5, 3, 1).do(over).expectStack(5, 3, 1, 3),
+		vmTest("rot").withStack(5, 3, 1).do(rot).expectStack(3, 1, 5),
+		vmTest("and").withStack(6, 3).do(and).expectStack(2),
+		vmTest("or").withStack(6, 3).do(or).expectStack(7),
+		vmTest("xor").withStack(6, 3).do(xor).expectStack(5),
+		vmTest("lshift").withStack(3, 2).do(lshift).expectStack(12),
+		vmTest("rshift").withStack(12, 2).do(rshift).expectStack(3),
+		vmTest("rshift unsigned").withStack(-1, 1).do(rshift).expectStack(int(^uint(0)>>1)),
+		vmTest("eq true").withStack(3, 3).do(eq).expectStack(1),
+		vmTest("eq false").withStack(3, 4).do(eq).expectStack(0),
+		vmTest("lt true").withStack(-3, 2).do(lt).expectStack(1),
+		vmTest("lt false").withStack(2, 2).do(lt).expectStack(0),
+		vmTest("dup underflow").withStack().do(dup).expectError(ErrStackUnderflow),
+
+		// is top of stack less than 0?
+		vmTest("less true").withStack(2, -3).do(under0).expectStack(2, 1),
+		vmTest("less false").withStack(2, 3).do(under0).expectStack(2, 0),
+
+		// pop top of stack, use as index into stack and copy up that element
+		vmTest("pick 0").withStack(1, 2, 3, 4, 5, 0).do(pick).expectStack(1, 2, 3, 4, 5, 5),
+		vmTest("pick 1").withStack(1, 2, 3, 4, 5, 1).do(pick).expectStack(1, 2, 3, 4, 5, 4),
+		vmTest("pick 2").withStack(1, 2, 3, 4, 5, 2).do(pick).expectStack(1, 2, 3, 4, 5, 3),
+		vmTest("pick 3").withStack(1, 2, 3, 4, 5, 3).do(pick).expectStack(1, 2, 3, 4, 5, 2),
+		vmTest("pick 4").withStack(1, 2, 3, 4, 5, 4).do(pick).expectStack(1, 2, 3, 4, 5, 1),
+		vmTest("pick 5").withStack(1, 2, 3, 4, 5, 5).do(pick).expectStack(1, 2, 3, 4, 5, 0),
+
+		// read from memory
+		vmTest("get").withMemAt(1024, 99, 42, 108).withStack(1025).do(get).expectStack(42),
+
+		// write to memory
+		vmTest("set").withMemAt(1024, 0, 0, 0).withStack(108, 1025).do(set).expectMemAt(1024, 0, 108, 0),
+
+		// push an immediate value onto the stack
+		vmTest("pushint").withMemAt(1024, 99, 42, 108).withProg(1025).do(pushint).expectStack(42).expectProg(1026),
+
+		// compile the program counter
+		vmTest("compileme").withMemAt(256, 2048).withR(256).withMemAt(1024,
+			0,             // 1024: word prev
+			vmCodeSub,     // 1025: ... name
+			vmCodeCompile, // 1026: ...
+			vmCodeRun,     // 1027: ...       <-- prog
+			vmCodeSub,     // 1028: ...
+			vmCodeExit,    // 1029: ...
+			0,             // 1030:
+			0,             // 1031:           <-- h
+			0,             // 1032:
+			0,             // 1033:
+		).withProg(1027).withH(1031).do(compileme).expectMemAt(1024,
+			0,             // 1024: word prev
+			vmCodeSub,     // 1025: ... name
+			vmCodeCompile, // 1026: ...
+			vmCodeRun,     // 1027: ...
+			vmCodeSub,     // 1028: ...
+			vmCodeExit,    // 1029: ...
+			0,             // 1030:
+			1028,          // 1031:
+			0,             // 1032:           <-- h
+			0,             // 1033:
+			//                ....:
+			//                2048:           <-- prog
+		).expectProg(2048).expectH(1032),
+
+		// compile the header of a definition
+		vmTest("define").withMemAt(1024,
+			0, // 1024: <-- h
+			0, // 1025:
+			0, // 1026:
+			0, // 1027:
+			0, // 1028:
+			0, // 1029:
+			0, // 1030:
+		).withH(1024).withInput(` : `).do(define).expectMemAt(1024,
+			0,             // 1024: word prev
+			1,             // 1025: word name
+			vmCodeCompile, // 1026: ...
+			vmCodeRun,     // 1027: ...
+			0,             // 1028:           <-- h
+			0,             // 1029:
+			0,             // 1030:
+		).expectH(1028).expectLast(1024).expectString(1, ":"),
+
+		// modify the header to create an immediate word
+		vmTest("immediate").withMemAt(1024,
+			0, // 1024: <-- h
+			0, // 1025:
+			0, // 1026:
+			0, // 1027:
+			0, // 1028:
+			0, // 1029:
+			0, // 1030:
+		).withH(1024).withInput(`:`).do(define, immediate).expectMemAt(1024,
+			0,         // 1024: word prev
+			1,         // 1025: ... name
+			vmCodeRun, // 1026: ...
+			vmCodeRun, // 1027: ...           <-- h
+			0,         // 1028:
+			0,         // 1029:
+		).expectH(1027).expectString(1, ":"),
+
+		// stop running the current function
+		vmTest("exit").withRetBase(256,
+			1024, // 256: ret[0]
+			1025, // 257: ret[1]
+			1026, // 258: ret[2]
+			1027, // 259: ret[3]
+		).withMemBase(1024, // NOTE this simply explicates the default 0 == exit value
+			vmCodeExit, // 1024:
+			vmCodeExit, // 1025:
+			vmCodeExit, // 1026:
+			vmCodeExit, // 1027:
+		).do(exit, step, nil).expectMemAt(256, // NOTE return stack not zerod
+			1024, // 256: ret[0]
+			1025, // 257: ret[1]
+			1026, // 258: ret[2]
+			1027, // 259: ret[3]
+		).expectProg(1025).expectR(255),
+
+		// read a word from input and compile a pointer to it
+		vmTest("read").withStrings(
+			1, "foo",
+			2, "bar",
+		).withMemAt(1024,
+			0,             // 1024:
+			1,             // 1025:
+			vmCodeCompile, // 1026:
+			vmCodeRun,     // 1027:
+			vmCodeSub,     // 1028:
+			vmCodeExit,    // 1029:           <-- prog
+			1024,          // 1030:           <-- last
+			2,             // 1031:
+			vmCodeRun,     // 1032:
+			vmCodeRun,     // 1033:           <-- h
+			0,             // 1034:
+			0,             // 1035:
+			0,             // 1036:
+		).withH(1033).withProg(1029).withLast(1030).withInput("foo").do(
+			read, step, nil,
+		).expectMemAt(1024,
+			0,             // 1024:
+			1,             // 1025:
+			vmCodeCompile, // 1026:
+			vmCodeRun,     // 1027:
+			vmCodeSub,     // 1028:
+			vmCodeExit,    // 1029:
+		).expectMemAt(1030,
+			1024,      // 1030:
+			2,         // 1031:
+			vmCodeRun, // 1032:
+			1028,      // 1033:
+			0,         // 1034:           <-- h
+			0,         // 1035:
+			0,         // 1036:
+		).expectH(1034),
+
+		// output one character
+		// input one character
+		vmTest("key^2 => echo^2").withInput("ab").do(key, key, echo, echo).expectOutput("ba"),
+	)
+
+	testCases.run(t)
+}
+
+func Test_primitives(t *testing.T) {
+	vmTestCases{
+		vmTest("host swap").withOptions(
+			WithPrimitive("swap", func(vm *VM) {
+				b, a := vm.Pop(), vm.Pop()
+				vm.Push(b, a)
+			}),
+		).withInput(testBuiltins+`
+			: test immediate 3 5 swap exit
+			test
+		`).expectWord(1092, "swap", vmCodeCompIt, vmCodeMax, vmCodeExit).expectStack(5, 3),
+
+		vmTest("host halt").withOptions(
+			WithPrimitive("bye", func(vm *VM) { vm.Halt(nil) }),
+		).withInput(testBuiltins + `
+			: test immediate 42 bye 99 exit
+			test
+		`).expectStack(42),
+	}.run(t)
+}
+
+func Test_stepLimit(t *testing.T) {
+	vmTestCases{
+		vmTest("under limit").withOptions(WithStepLimit(100)).withInput(testBuiltins + `
+			: test immediate 3 4 * exit
+			test
+		`).expectStack(12),
+
+		vmTest("over limit").withOptions(WithStepLimit(100)).withInput(testBuiltins + `
+			: loop 0 1 - * loop exit
+			: test immediate 1 loop exit
+			test
+		`).expectError(StepLimitError{Steps: 100, Prog: 1096, Word: "loop"}),
+	}.run(t)
+
+	vm := New(WithStepLimit(100), WithInput(strings.NewReader(testBuiltins+`
+		: loop 0 1 - * loop exit
+		: test immediate 1 loop exit
+		test
+	`)))
+	var vmErr VMError
+	if err := vm.Run(context.Background()); assert.True(t, errors.As(err, &vmErr), "expected a VMError, got %v", err) {
+		assert.Equal(t, "loop", vmErr.Word, "expected VMError to agree on the word")
+		assert.Equal(t, uint(1096), vmErr.Addr, "expected VMError to agree on the address")
+	}
+}
+
+func Test_stackErrors(t *testing.T) {
+	vmTestCases{
+		vmTest("underflow").withInput(testBuiltins + `
+			: test immediate 3 - exit
+			test
+		`).expectError(ErrStackUnderflow),
+
+		vmTest("under limit").withOptions(WithStackLimit(3)).withInput(testBuiltins+`
+			: test immediate 1 2 3 exit
+			test
+		`).expectStack(1, 2, 3),
+
+		vmTest("over limit").withOptions(WithStackLimit(3)).withInput(testBuiltins + `
+			: test immediate 1 2 3 4 exit
+			test
+		`).expectError(ErrStackOverflow),
+	}.run(t)
+
+	err := New(WithInput(strings.NewReader(testBuiltins + `
+		: test immediate 3 - exit
+		test
+	`))).Run(context.Background())
+	var under StackUnderflowError
+	if assert.True(t, errors.As(err, &under), "expected a StackUnderflowError, got %v", err) {
+		assert.Equal(t, StackError{Addr: 1097, Word: "test"}, under.StackError)
+	}
+}
+
+func Test_VMError(t *testing.T) {
+	err := New(
+		WithInput(namedString{"builtins", strings.NewReader(testBuiltins)}),
+		WithInput(namedString{"test", strings.NewReader(`
+			: test immediate 5 3 - - exit
+			test
+		`)}),
+	).Run(context.Background())
+
+	var vmErr VMError
+	if assert.True(t, errors.As(err, &vmErr), "expected a VMError, got %v", err) {
+		assert.Equal(t, "test:3:4", vmErr.Location.String(), "expected error location")
+		assert.Equal(t, "test", vmErr.Word, "expected error word")
+		assert.Equal(t, uint(1100), vmErr.Addr, "expected error address")
+		assert.Equal(t, uint(1101), vmErr.Prog, "expected error program counter")
+		assert.Equal(t, []int{}, vmErr.Stack, "expected error stack")
+		assert.Equal(t, []int{1029, 1029, 1029, 1029, 1029, 1029, 1029, 1028}, vmErr.RStack, "expected error return stack")
+		assert.Equal(t, "test:3:4: in word test: stack underflow @1100 in test s:[]", err.Error())
+	}
+	assert.True(t, errors.Is(err, ErrStackUnderflow), "expected a stack underflow")
+}
+
+func Test_scanSpans(t *testing.T) {
+	vm := New(
+		WithInput(namedString{"a", strings.NewReader("foo  bär\n  baz")}),
+		WithInput(namedString{"b", strings.NewReader("quux")}),
+	)
+	type scanned struct {
+		token  string
+		span   string
+		offset int
+	}
+	var got []scanned
+	assert.NoError(t, vm.guard(func() error {
+		for i := 0; i < 4; i++ {
+			token, span := vm.scan()
+			got = append(got, scanned{token, span.String(), span.Start.Offset})
+		}
+		return nil
+	}))
+	assert.Equal(t, []scanned{
+		{"foo", "a:1:1-4", 0},
+		{"bär", "a:1:6-9", 5},
+		{"baz", "a:2:3-6", 12},
+		{"quux", "b:1:1-5", 0},
+	}, got)
+}
+
+func Test_sourceMap(t *testing.T) {
+	var dump strings.Builder
+	vm := New(
+		WithSourceMap(),
+		WithInput(namedString{"builtins", strings.NewReader(testBuiltins)}),
+		WithInput(namedString{"test", strings.NewReader(
+			": sq 0 pick * exit\n" +
+				": test immediate\n  3 sq sq -\n  exit\n" +
+				"test\n")}),
+	)
+	err := vm.Run(context.Background())
+
+	for _, tc := range []struct {
+		addr uint
+		loc  string
+	}{
+		{1087, "builtins:1:46"}, // pick header
+		{1092, "test:1:3"},      // sq header
+		{1096, "test:1:6"},      // sq: pushint
+		{1097, "test:1:6"},      // sq: 0
+		{1098, "test:1:8"},      // sq: pick
+		{1104, "test:3:3"},      // test: pushint
+		{1106, "test:3:5"},      // test: sq
+		{1107, "test:3:8"},      // test: sq
+	} {
+		loc, ok := vm.SourceOf(tc.addr)
+		if assert.True(t, ok, "expected source @%v", tc.addr) {
+			assert.Equal(t, tc.loc, loc.String(), "expected source @%v", tc.addr)
+		}
+	}
+
+	var vmErr VMError
+	if assert.True(t, errors.As(err, &vmErr), "expected a VMError, got %v", err) {
+		assert.Equal(t, "test:3:11", vmErr.Source.String(), "expected error source")
+	}
+
+	vm.Dump(&dump)
+	assert.Contains(t, dump.String(), "  @ 1092 : sq runme pushint(0) pick mul exit # test:1:3\n")
+	_, ok := vm.SourceOf(1024)
+	assert.False(t, ok, "expected no source for the entry word")
+
+	t.Run("unsourced", func(t *testing.T) {
+		vm := New(
+			WithSourceMap(),
+			WithPrimitive("nop", func(vm *VM) {}),
+			WithRecovery(func(err error) {}),
+			WithInput(namedString{"builtins", strings.NewReader(testBuiltins)}),
+			WithInput(namedString{"test", strings.NewReader(
+				": foo 1 bogus\n")}),
+		)
+		require.NoError(t, vm.Run(context.Background()))
+		_, ok := vm.SourceOf(1092)
+		assert.False(t, ok, "expected no source for host primitive")
+		for addr := uint(1097); addr < 1103; addr++ {
+			_, ok := vm.SourceOf(addr)
+			assert.False(t, ok, "expected no source @%v after rollback", addr)
+		}
+	})
+}
+
+func Test_registerCache(t *testing.T) {
+	vm := New()
+	require.NoError(t, vm.guard(func() error {
+		vm.init()
+		vm.pushr(42)
+		assert.Equal(t, 256, vm.Load(1), "expected pushr to update r in memory")
+
+		vm.Stor(1, 300)
+		vm.pushr(99)
+		assert.Equal(t, 301, vm.Load(1), "expected pushr to see r stored through memory")
+		assert.Equal(t, 99, vm.Load(301), "expected return stack value")
+		assert.Equal(t, uint(99), vm.popr(), "expected popr value")
+		return nil
+	}))
+}
+
+func Test_lookupIndex(t *testing.T) {
+	vm := New(WithInput(strings.NewReader(testBuiltins + `
+		: foo immediate 1 exit
+		: bar immediate 2 exit
+		: foo immediate 3 exit
+	`)))
+	require.NoError(t, vm.Run(context.Background()))
+
+	var foo, bar uint
+	require.NoError(t, vm.guard(func() error {
+		foo, bar = vm.lookup("foo"), vm.lookup("bar")
+		assert.Equal(t, vm.last, foo, "expected latest foo")
+		assert.Less(t, bar, foo, "expected bar before latest foo")
+		assert.Equal(t, uint(0), vm.lookup("nope"), "expected no such word")
+
+		// forgetting the latest foo reveals the prior one
+		vm.forget(foo, bar)
+		prior := vm.lookup("foo")
+		assert.NotEqual(t, uint(0), prior, "expected prior foo")
+		assert.Less(t, prior, bar, "expected prior foo before bar")
+
+		// the in-memory list stays authoritative over the index, even for
+		// names that weren't found
+		nope := vm.symbolicate("nope")
+		assert.Equal(t, uint(0), vm.lookup("nope"), "expected no such word")
+		vm.stor(bar+1, int(nope))
+		assert.Equal(t, uint(0), vm.lookup("bar"), "expected renamed bar to be gone")
+		assert.Equal(t, bar, vm.lookup("nope"), "expected bar under its new name")
+		return nil
+	}))
+}
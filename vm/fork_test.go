@@ -0,0 +1,57 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Fork(t *testing.T) {
+	base := New(
+		WithInputWriter(ThirdKernel),
+		WithInput(strings.NewReader(`
+			: sq dup * ;
+		`)),
+	)
+	require.NoError(t, base.Run(context.Background()), "must boot kernel")
+
+	var wg sync.WaitGroup
+	outs := make([]strings.Builder, 8)
+	for i := range outs {
+		vm := base.Fork(
+			WithInput(strings.NewReader(fmt.Sprintf(`
+				: n%[1]v %[1]v ;
+				: test immediate n%[1]v sq . ;
+				test
+			`, i))),
+			WithOutput(&outs[i]),
+		)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, vm.Run(context.Background()), "unexpected fork run error")
+		}()
+	}
+	wg.Wait()
+	for i := range outs {
+		assert.Equal(t, fmt.Sprintf("%v ", i*i), outs[i].String(), "expected fork #%v output", i)
+	}
+
+	var out strings.Builder
+	vm := base.Fork(
+		WithInput(strings.NewReader(`
+			: test immediate 7 sq . ;
+			test
+		`)),
+		WithOutput(&out),
+	)
+	assert.NoError(t, vm.Run(context.Background()), "unexpected fork run error")
+	assert.Equal(t, "49 ", out.String(), "expected fork output")
+	assert.NotContains(t, vm.Words(), "n1", "expected no words from sibling forks")
+	assert.NotContains(t, base.Words(), "test", "expected no words from forks")
+}
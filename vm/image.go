@@ -0,0 +1,324 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An image captures the state of a VM so that it may be resumed later, or
+// forked any number of times, without paying to recompile its dictionary.
+//
+// The format is a magic string followed by a version number and then a
+// sequence of varint encoded fields:
+//   - the memory page size, program counter, last word, and end of builtins
+//   - the data stack
+//   - the names of any host primitives, which must match when restored
+//   - the symbol strings
+//   - each allocated memory page, as a base address and values
+//
+// Input, output, logging, and host primitive functions are not captured; they
+// must be supplied anew by the restoring VM's options.
+const (
+	imageMagic   = "gothird\x00"
+	imageVersion = 1
+
+	// imageMaxLen bounds every count and length decoded from an image, so that
+	// a corrupt one can't grow memory without bound; stack depth and page
+	// extents are further bounded by any stack or memory limit.
+	imageMaxLen = 1 << 24
+)
+
+var errImageMagic = errors.New("not a gothird image")
+
+type imageVersionError uint64
+
+type imagePrimitiveError struct {
+	code uint
+	have string
+	want string
+}
+
+type imageLenError struct {
+	what string
+	n    uint64
+	max  uint64
+}
+
+type imageBoundsError struct {
+	what   string
+	val    uint
+	lo, hi uint
+}
+
+func (ver imageVersionError) Error() string {
+	return fmt.Sprintf("unsupported image version %v", uint64(ver))
+}
+
+func (prim imagePrimitiveError) Error() string {
+	if prim.have == "" {
+		return fmt.Sprintf("image primitive #%v %q missing", prim.code, prim.want)
+	}
+	return fmt.Sprintf("image primitive #%v is %q, expected %q", prim.code, prim.have, prim.want)
+}
+
+func (err imageLenError) Error() string {
+	return fmt.Sprintf("image %v %v exceeds limit %v", err.what, err.n, err.max)
+}
+
+func (err imageBoundsError) Error() string {
+	return fmt.Sprintf("image %v %v out of bounds [%v, %v)", err.what, err.val, err.lo, err.hi)
+}
+
+// SaveImage writes an image of the VM's memory, symbols, and stacks to w, see
+// WithImage.
+func (vm *VM) SaveImage(w io.Writer) error {
+	enc := imageEncoder{w: bufio.NewWriter(w)}
+	enc.w.WriteString(imageMagic)
+	enc.uint(imageVersion)
+
+	enc.uint(uint64(vm.mem.PageSize))
+	enc.uint(uint64(vm.prog))
+	enc.uint(uint64(vm.last))
+	enc.uint(uint64(vm.builtinsEnd))
+
+	enc.uint(uint64(len(vm.stack)))
+	for _, val := range vm.stack {
+		enc.int(val)
+	}
+
+	enc.uint(uint64(len(vm.prims)))
+	for _, prim := range vm.prims {
+		enc.string(prim.name)
+	}
+
+	enc.uint(uint64(len(vm.symbols.strings)))
+	for _, s := range vm.symbols.strings {
+		enc.string(s)
+	}
+
+	var numPages uint64
+	vm.mem.EachPage(func(uint, []int) error {
+		numPages++
+		return nil
+	})
+	enc.uint(numPages)
+	vm.mem.EachPage(func(base uint, page []int) error {
+		enc.uint(uint64(base))
+		enc.uint(uint64(len(page)))
+		for _, val := range page {
+			enc.int(val)
+		}
+		return enc.err
+	})
+
+	if enc.err != nil {
+		return enc.err
+	}
+	return enc.w.Flush()
+}
+
+// WithImage restores VM state from an image previously written by SaveImage.
+// The image is read when the VM starts running: rather than compiling FIRST's
+// builtins anew, the VM then resumes from the image's program counter.
+func WithImage(r io.Reader) VMOption { return imageOption{r} }
+
+type imageOption struct{ io.Reader }
+
+func (img imageOption) apply(vm *VM) {
+	vm.image = img.Reader
+}
+
+func (vm *VM) loadImage() {
+	br, ok := vm.image.(io.ByteReader)
+	if !ok {
+		br = bufio.NewReader(vm.image)
+	}
+	dec := imageDecoder{r: br}
+	if err := dec.decode(vm); err == io.EOF {
+		vm.halt(io.ErrUnexpectedEOF)
+	} else if err != nil {
+		vm.halt(err)
+	}
+	vm.image = nil
+
+	var hr [2]int
+	vm.loadInto(0, hr[:])
+	vm.markHigh(0, hr[:])
+
+	if err := vm.indexImage(); err != nil {
+		vm.last = 0 // so that naming the halted word doesn't walk the dictionary
+		vm.halt(err)
+	}
+}
+
+// indexImage rebuilds the lookup index from a restored dictionary, checking it
+// first, since the image may be corrupt: builtins must end within memBase..h,
+// every word must lie within memBase..h, after the word that it links to, and
+// every word's name must be a restored symbol.
+func (vm *VM) indexImage() error {
+	memBase, h := uint(vm.load(11)), uint(vm.load(0))
+	if vm.builtinsEnd < memBase || vm.builtinsEnd > h {
+		return imageBoundsError{"builtins end", vm.builtinsEnd, memBase, h + 1}
+	}
+	vm.clearIndex()
+	end := h
+	for word := vm.last; word != 0; word = uint(vm.load(word)) {
+		if word < memBase || word+3 > end {
+			return imageBoundsError{"word", word, memBase, end - 2}
+		}
+		end = word
+		name := uint(vm.load(word + 1))
+		if max := uint(len(vm.symbols.strings)); name > max {
+			return imageBoundsError{"word name", name, 0, max + 1}
+		}
+		if _, seen := vm.words[name]; !seen && name != 0 {
+			vm.indexWord(name, word)
+		}
+	}
+	return nil
+}
+
+func (dec *imageDecoder) decode(vm *VM) error {
+	var magic [len(imageMagic)]byte
+	for i := range magic {
+		magic[i], dec.err = dec.r.ReadByte()
+		if dec.err != nil {
+			return dec.err
+		}
+	}
+	if string(magic[:]) != imageMagic {
+		return errImageMagic
+	}
+	if ver := dec.uint(); dec.err != nil {
+		return dec.err
+	} else if ver != imageVersion {
+		return imageVersionError(ver)
+	}
+
+	vm.mem.PageSize = uint(dec.len("page size", imageMaxLen))
+	if dec.err == nil && vm.mem.PageSize == 0 {
+		return imageBoundsError{"page size", 0, 1, imageMaxLen + 1}
+	}
+	vm.prog = uint(dec.uint())
+	vm.last = uint(dec.uint())
+	vm.builtinsEnd = uint(dec.uint())
+	vm.clearIndex()
+
+	maxStack := uint64(imageMaxLen)
+	if vm.stackLimit != 0 {
+		maxStack = uint64(vm.stackLimit)
+	}
+	vm.stack = vm.stack[:0]
+	for n := dec.len("stack depth", maxStack); n > 0 && dec.err == nil; n-- {
+		vm.stack = append(vm.stack, dec.int())
+	}
+
+	for i, n := uint(0), uint(dec.len("primitive count", imageMaxLen)); i < n && dec.err == nil; i++ {
+		name := dec.string()
+		if i >= uint(len(vm.prims)) {
+			return imagePrimitiveError{vmCodeMax + i, "", name}
+		} else if have := vm.prims[i].name; have != name {
+			return imagePrimitiveError{vmCodeMax + i, have, name}
+		}
+	}
+
+	vm.symbols = symbols{symbols: make(map[string]uint)}
+	for n := dec.len("symbol count", imageMaxLen); n > 0 && dec.err == nil; n-- {
+		s := dec.string()
+		vm.symbols.strings = append(vm.symbols.strings, s)
+		vm.symbols.symbols[s] = uint(len(vm.symbols.strings))
+	}
+
+	maxEnd := uint64(imageMaxLen)
+	if vm.mem.Limit != 0 {
+		maxEnd = uint64(vm.mem.Limit)
+	}
+	var page []int
+	for n := dec.len("page count", imageMaxLen); n > 0 && dec.err == nil; n-- {
+		base := dec.len("page base", maxEnd)
+		page = page[:0]
+		for m := dec.len("page length", maxEnd-base); m > 0 && dec.err == nil; m-- {
+			page = append(page, dec.int())
+		}
+		if dec.err == nil {
+			dec.err = vm.mem.Stor(uint(base), page...)
+		}
+	}
+
+	return dec.err
+}
+
+type imageEncoder struct {
+	w   *bufio.Writer
+	buf [binary.MaxVarintLen64]byte
+	err error
+}
+
+func (enc *imageEncoder) uint(val uint64) {
+	if enc.err == nil {
+		_, enc.err = enc.w.Write(enc.buf[:binary.PutUvarint(enc.buf[:], val)])
+	}
+}
+
+func (enc *imageEncoder) int(val int) {
+	if enc.err == nil {
+		_, enc.err = enc.w.Write(enc.buf[:binary.PutVarint(enc.buf[:], int64(val))])
+	}
+}
+
+func (enc *imageEncoder) string(s string) {
+	enc.uint(uint64(len(s)))
+	if enc.err == nil {
+		_, enc.err = enc.w.WriteString(s)
+	}
+}
+
+type imageDecoder struct {
+	r   io.ByteReader
+	err error
+}
+
+func (dec *imageDecoder) uint() (val uint64) {
+	if dec.err == nil {
+		val, dec.err = binary.ReadUvarint(dec.r)
+	}
+	return val
+}
+
+// len decodes a count or length, failing if it exceeds max.
+func (dec *imageDecoder) len(what string, max uint64) uint64 {
+	n := dec.uint()
+	if dec.err == nil && n > max {
+		dec.err = imageLenError{what, n, max}
+	}
+	if dec.err != nil {
+		return 0
+	}
+	return n
+}
+
+func (dec *imageDecoder) int() (val int) {
+	if dec.err == nil {
+		var v int64
+		v, dec.err = binary.ReadVarint(dec.r)
+		val = int(v)
+	}
+	return val
+}
+
+func (dec *imageDecoder) string() string {
+	n := dec.len("string length", imageMaxLen)
+	if dec.err != nil {
+		return ""
+	}
+	sb := make([]byte, 0, n)
+	for ; n > 0 && dec.err == nil; n-- {
+		var b byte
+		b, dec.err = dec.r.ReadByte()
+		sb = append(sb, b)
+	}
+	return string(sb)
+}
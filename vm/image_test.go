@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_image(t *testing.T) {
+	var image bytes.Buffer
+	{
+		vm := New(
+			WithInputWriter(ThirdKernel),
+			WithInput(strings.NewReader("\n[\n")),
+		)
+		require.NoError(t, vm.Run(context.Background()), "must boot kernel")
+		require.NoError(t, vm.SaveImage(&image), "must save image")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		input  string
+		output string
+	}{
+		{"add", "3 5 + printnum", "8"},
+		{"square", "7 dup * printnum", "49"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out strings.Builder
+			vm := New(
+				WithImage(bytes.NewReader(image.Bytes())),
+				WithInput(strings.NewReader(tc.input)),
+				WithOutput(&out),
+			)
+			assert.NoError(t, vm.Run(context.Background()), "unexpected run error")
+			assert.Equal(t, tc.output, out.String(), "expected output")
+		})
+	}
+
+	t.Run("bad magic", func(t *testing.T) {
+		vm := New(WithImage(strings.NewReader("nope")))
+		assert.Error(t, vm.Run(context.Background()), "expected image error")
+	})
+
+	t.Run("empty return stack", func(t *testing.T) {
+		var image bytes.Buffer
+		vm := New(WithInput(strings.NewReader(testBuiltins)))
+		require.NoError(t, vm.Run(context.Background()), "must run builtins")
+		vm.stor(1, vm.load(10)-1)
+		vm.prog = uint(vm.load(11)) + 3 // restart the entry word
+		require.NoError(t, vm.SaveImage(&image), "must save image")
+
+		vm = New(WithImage(&image))
+		assert.NoError(t, vm.Run(context.Background()), "expected empty return stack to restore")
+	})
+
+	for _, tc := range []struct {
+		name    string
+		corrupt func(vm *VM)
+	}{
+		{"bad last", func(vm *VM) { vm.last = 1 << 20 }},
+		{"bad link", func(vm *VM) { vm.stor(vm.last, int(vm.last)) }},
+		{"bad name", func(vm *VM) { vm.stor(vm.last+1, 1<<20) }},
+		{"bad builtins end", func(vm *VM) { vm.builtinsEnd = 1 }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var image bytes.Buffer
+			vm := New(WithInput(strings.NewReader(testBuiltins + ": sq 0 pick * exit\n")))
+			require.NoError(t, vm.Run(context.Background()), "must run builtins")
+			tc.corrupt(vm)
+			require.NoError(t, vm.SaveImage(&image), "must save image")
+
+			vm = New(WithImage(&image))
+			var boundsErr imageBoundsError
+			err := vm.Run(context.Background())
+			assert.True(t, errors.As(err, &boundsErr), "expected bounds error, got %v", err)
+		})
+	}
+
+	t.Run("oversized", func(t *testing.T) {
+		for _, tc := range []struct {
+			name   string
+			fields []uint64
+		}{
+			{"page size", []uint64{imageMaxLen + 1}},
+			{"stack depth", []uint64{255, 0, 0, 0, 1 << 40}},
+			{"string length", []uint64{255, 0, 0, 0, 0, 0, 1, 1 << 40}},
+			{"page length", []uint64{255, 0, 0, 0, 0, 0, 0, 1, 0, 1 << 40}},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				var image bytes.Buffer
+				enc := imageEncoder{w: bufio.NewWriter(&image)}
+				enc.w.WriteString(imageMagic)
+				enc.uint(imageVersion)
+				for _, field := range tc.fields {
+					enc.uint(field)
+				}
+				require.NoError(t, enc.w.Flush(), "must encode image")
+
+				vm := New(WithImage(&image))
+				var lenErr imageLenError
+				err := vm.Run(context.Background())
+				if assert.True(t, errors.As(err, &lenErr), "expected length error, got %v", err) {
+					assert.Equal(t, tc.name, lenErr.what, "expected oversized field")
+				}
+			})
+		}
+	})
+
+	t.Run("primitive mismatch", func(t *testing.T) {
+		var image bytes.Buffer
+		vm := New(
+			WithPrimitive("nop", func(vm *VM) {}),
+			WithInput(strings.NewReader(testBuiltins)),
+		)
+		require.NoError(t, vm.Run(context.Background()), "must run builtins")
+		require.NoError(t, vm.SaveImage(&image), "must save image")
+
+		vm = New(WithImage(&image))
+		var primErr imagePrimitiveError
+		assert.True(t, errors.As(vm.Run(context.Background()), &primErr), "expected primitive error")
+	})
+}
@@ -0,0 +1,103 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// maxIncludeDepth limits include nesting, so that a file including itself
+// fails quickly rather than exhausting file descriptors.
+const maxIncludeDepth = 64
+
+// WithInclude adds an immediate "include" host primitive, that reads the next token
+// from input as a file name, and splices that file into input at the current
+// read position; once it's exhausted, reading resumes after the include.
+//
+// Relative names are resolved against the directory of the including input,
+// if it was itself included or added WithInputFile, otherwise against the
+// working directory.
+// Files are opened with open, or os.Open if it is nil.
+func WithInclude(open func(name string) (io.Reader, error)) VMOption {
+	if open == nil {
+		open = func(name string) (io.Reader, error) { return os.Open(name) }
+	}
+	return primitiveOption{"include", func(vm *VM) {
+		name, _ := vm.scan()
+		if !filepath.IsAbs(name) && vm.includesRelative() {
+			name = filepath.Join(filepath.Dir(vm.Pos.Name), name)
+		}
+		if vm.Depth() >= maxIncludeDepth {
+			vm.halt(includeError{name, errIncludeDepth})
+		}
+		r, err := open(name)
+		if err != nil {
+			vm.halt(includeError{name, err})
+		}
+		r = withName(r, name)
+		vm.markFile(nameOf(r))
+		vm.Input.Push(r)
+	}, true}
+}
+
+// WithInputFile adds input from r, as read from the file at path, so that any
+// includes from it resolve relative names against its directory.
+func WithInputFile(path string, r io.Reader) VMOption { return inputFileOption{path, r} }
+
+type inputFileOption struct {
+	path string
+	r    io.Reader
+}
+
+func (o inputFileOption) apply(vm *VM) {
+	r := withName(o.r, o.path)
+	vm.markFile(nameOf(r))
+	vm.Queue = append(vm.Queue, r)
+}
+
+// markFile records that the input named name was read from a file.
+func (vm *VM) markFile(name string) {
+	if vm.fileNames == nil {
+		vm.fileNames = make(map[string]bool)
+	}
+	vm.fileNames[name] = true
+}
+
+// includesRelative returns true if the current input was read from a file,
+// rather than some other stream, like stdin, whose name isn't a usable path.
+func (vm *VM) includesRelative() bool { return vm.fileNames[vm.Pos.Name] }
+
+var errIncludeDepth = fmt.Errorf("include nesting deeper than %v", maxIncludeDepth)
+
+type includeError struct {
+	name string
+	err  error
+}
+
+func (ie includeError) Error() string { return fmt.Sprintf("include %v: %v", ie.name, ie.err) }
+func (ie includeError) Unwrap() error { return ie.err }
+
+// withName returns r if it already has a name, otherwise wrapping it to
+// provide one, while retaining any Close method.
+func withName(r io.Reader, name string) io.Reader {
+	if _, named := r.(interface{ Name() string }); named {
+		return r
+	}
+	if cl, ok := r.(io.Closer); ok {
+		return namedReadCloser{namedReader{r, name}, cl}
+	}
+	return namedReader{r, name}
+}
+
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+type namedReadCloser struct {
+	namedReader
+	io.Closer
+}
+
+func (nr namedReader) Name() string { return nr.name }
@@ -0,0 +1,75 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_include(t *testing.T) {
+	files := map[string]string{
+		"lib/sq.th":   ": sq 0 pick * exit\n",
+		"lib/cube.th": "include sq.th\n: cube 0 pick sq * exit\n",
+		"loop.th":     "include loop.th\n",
+	}
+	open := func(name string) (io.Reader, error) {
+		if src, ok := files[name]; ok {
+			return strings.NewReader(src), nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		vm := New(
+			WithInclude(open),
+			WithInput(namedString{"main", strings.NewReader(testBuiltins +
+				"include lib/cube.th : test immediate 3 cube exit\n" +
+				"test\n" +
+				"bogus\n")}),
+		)
+		err := vm.Run(context.Background())
+		assert.Equal(t, []int{27}, vm.Stack(), "expected cube result")
+
+		var vmErr VMError
+		if assert.True(t, errors.As(err, &vmErr), "expected a VMError, got %v", err) {
+			assert.Equal(t, "main:4:1", vmErr.Location.String(), "expected location to resume in main")
+		}
+	})
+
+	t.Run("relative", func(t *testing.T) {
+		const main = testBuiltins + "include sq.th : test immediate 3 sq exit\ntest\n"
+		vm := New(
+			WithInclude(open),
+			WithInputFile("lib/main.th", strings.NewReader(main)),
+		)
+		assert.NoError(t, vm.Run(context.Background()), "expected include relative to input file")
+		assert.Equal(t, []int{9}, vm.Stack(), "expected sq result")
+
+		err := New(
+			WithInclude(open),
+			WithInput(namedString{"lib/main.th", strings.NewReader(main)}),
+		).Run(context.Background())
+		assert.True(t, errors.Is(err, os.ErrNotExist), "expected include relative to working directory, got %v", err)
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		err := New(
+			WithInclude(open),
+			WithInput(strings.NewReader(testBuiltins+"include nope.th\n")),
+		).Run(context.Background())
+		assert.True(t, errors.Is(err, os.ErrNotExist), "expected not exist error, got %v", err)
+	})
+
+	t.Run("recursive", func(t *testing.T) {
+		err := New(
+			WithInclude(open),
+			WithInput(strings.NewReader(testBuiltins+"include loop.th\n")),
+		).Run(context.Background())
+		assert.True(t, errors.Is(err, errIncludeDepth), "expected include depth error, got %v", err)
+	})
+}
@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Reset(t *testing.T) {
+	vmTestCases{
+		vmTest("stack underflow").
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", "\n[\ndrop\n7 dup * printnum\n").
+			expectError(ErrStackUnderflow).
+			expectThat(func(t *testing.T, vm *VM) {
+				vm.Reset()
+				assert.NoError(t, vm.Run(context.Background()), "expected no error after reset")
+			}).
+			expectOutput("49"),
+	}.run(t)
+}
+
+func Test_Compiling(t *testing.T) {
+	expectCompiling := func(want bool) func(vmTestCase) vmTestCase {
+		return func(vmt vmTestCase) vmTestCase {
+			return vmt.expectThat(func(t *testing.T, vm *VM) {
+				assert.Equal(t, want, vm.Compiling(), "expected compiling state")
+			})
+		}
+	}
+	compilingTest := func(name, input string) vmTestCase {
+		return vmTest(name).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", input)
+	}
+	vmTestCases{
+		compilingTest("command mode", ": sq dup * ;\n[\n3 sq\n").apply(expectCompiling(false)),
+		compilingTest("command mode allocation", ": sq dup * ;\n[\n5 ,\n").apply(expectCompiling(false)),
+		compilingTest("complete", ": sq dup * ;\n").apply(expectCompiling(false)),
+		compilingTest("complete with if", ": abs dup <0 if minus then ;\n").apply(expectCompiling(false)),
+		compilingTest("incomplete", ": sq dup * ;\n: half 2 if\n").apply(expectCompiling(true)),
+		compilingTest("incomplete literal", ": sq dup * ;\n: zero 0\n").apply(expectCompiling(true)),
+	}.run(t)
+}
+
+func Test_recovery(t *testing.T) {
+	expectWords := func(has []string, hasNot ...string) func(vmTestCase) vmTestCase {
+		return func(vmt vmTestCase) vmTestCase {
+			return vmt.expectThat(func(t *testing.T, vm *VM) {
+				words := vm.Words()
+				for _, name := range has {
+					assert.Contains(t, words, name, "expected completed definition")
+				}
+				for _, name := range hasNot {
+					assert.NotContains(t, words, name, "expected partial definition to be discarded")
+				}
+			})
+		}
+	}
+	vmTestCases{
+		vmTest("builtins").
+			withNamedInput("builtins", testBuiltins).
+			withNamedInput("test", `
+				: foo immediate 1 bogus
+				: bar immediate 7 exit
+				foo
+				bar
+			`).
+			expectRecovered(
+				`test:2:23: in word ø: invalid literal "bogus"`,
+				`test:4:5: in word ø: invalid literal "foo"`,
+			).
+			expectStack(7).
+			apply(expectWords([]string{"bar"}, "foo")),
+
+		vmTest("command mode").
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", ": sq dup * ;\n[\n3 sq printnum nl\nbogus\n4 sq printnum nl\n").
+			expectRecovered(`test:4:1: in word command: invalid literal "bogus"`).
+			expectOutput("9\n16\n"),
+
+		vmTest("kernel definition").
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", ": sq dup * ;\n: cube dup sq * ;\n: half 2 if 1 bogus\n3 cube printnum nl\n").
+			expectRecovered(`test:3:15: in word ]: invalid literal "bogus"`).
+			expectOutput("27\n").
+			apply(expectWords([]string{"cube"}, "half")),
+
+		vmTest("kernel definition after if").
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", ": half 2 if\nbogus\n").
+			expectRecovered(`test:2:1: in word ]: invalid literal "bogus"`).
+			apply(expectWords(nil, "half")),
+
+		vmTest("command mode allocation").
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", ": sq dup * ;\n[\n5 , bogus\n3 sq printnum nl\n").
+			expectRecovered(`test:3:5: in word command: invalid literal "bogus"`).
+			expectOutput("9\n"),
+	}.run(t)
+}
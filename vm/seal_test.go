@@ -0,0 +1,42 @@
+package vm
+
+import (
+	"testing"
+)
+
+func Test_seal(t *testing.T) {
+	vmTestCases{
+		vmTest("kernel").withOptions(WithSeal()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", `
+				: test immediate 7 dup * . ;
+				test
+			`).
+			expectOutput("49 "),
+
+		vmTest("memBase").withOptions(WithSeal()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", `
+				: test immediate 0 11 ! ;
+				test
+			`).
+			expectError(ProtectionError{Addr: 11, Op: "stor"}),
+
+		vmTest("builtin").withOptions(WithSeal()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", `
+				: test immediate 0 1033 ! ;
+				test
+			`).
+			expectError(ProtectionError{Addr: 1033, Op: "stor"}),
+
+		vmTest("caught").withOptions(WithSeal(), WithExceptions()).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", `
+				: poke 0 10 ! ;
+				: test immediate ' poke catch . ;
+				test
+			`).
+			expectOutput("-9 "),
+	}.run(t)
+}
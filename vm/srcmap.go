@@ -0,0 +1,41 @@
+package vm
+
+// A sourceMap records the input location of the token that caused each
+// dictionary cell to be compiled, see WithSourceMap.
+type sourceMap map[uint]Location
+
+// WithSourceMap enables recording the input location of every compiled
+// dictionary cell, which is then reported by SourceOf, VM dumps, tracing, and
+// VMErrors.
+func WithSourceMap() VMOption { return sourceMapOption{} }
+
+type sourceMapOption struct{}
+
+func (sourceMapOption) apply(vm *VM) {
+	if vm.srcmap == nil {
+		vm.srcmap = make(sourceMap)
+	}
+}
+
+func (srcmap sourceMap) record(addr uint, loc Location) {
+	if srcmap != nil {
+		srcmap[addr] = loc
+	}
+}
+
+// forget drops the locations of any cells at or above addr, e.g. after the
+// dictionary is rolled back, so that reused addresses don't report them.
+func (srcmap sourceMap) forget(addr uint) {
+	for at := range srcmap {
+		if at >= addr {
+			delete(srcmap, at)
+		}
+	}
+}
+
+// SourceOf returns the input location that addr was compiled from; it only
+// returns true if the VM was created WithSourceMap.
+func (vm *VM) SourceOf(addr uint) (loc Location, ok bool) {
+	loc, ok = vm.srcmap[addr]
+	return loc, ok
+}
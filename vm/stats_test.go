@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const factInput = `
+	: fact dup 1 - dup if fact * exit then drop ;
+	: test immediate 5 fact . ;
+	test
+`
+
+func Test_Stats(t *testing.T) {
+	vm := New(
+		WithInputWriter(ThirdKernel),
+		WithInput(namedString{"test", strings.NewReader(factInput)}),
+	)
+	before := vm.Stats()
+	require.NoError(t, vm.Run(context.Background()))
+	stats := vm.Stats()
+	assert.Equal(t, Stats{}, before, "expected no stats before boot")
+	assert.Equal(t, uint(vm.Load(0)), stats.MaxH, "expected h high-water mark")
+	assert.Greater(t, stats.MaxR, uint(vm.Load(10)), "expected r high-water mark above retBase")
+	assert.Greater(t, stats.Pages, 0, "expected pages")
+	assert.Greater(t, stats.Loads, stats.Stors, "expected more loads than stores")
+}
+
+func Test_compaction(t *testing.T) {
+	var plain, compacted *VM
+	keepVM := func(vm **VM) func(vmTestCase) vmTestCase {
+		return func(vmt vmTestCase) vmTestCase {
+			return vmt.expectThat(func(t *testing.T, got *VM) { *vm = got })
+		}
+	}
+	vmTestCases{
+		vmTest("plain").
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", factInput).
+			expectOutput("120 ").
+			apply(keepVM(&plain)),
+
+		vmTest("compacted").withOptions(WithCompaction(1)).
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", factInput).
+			expectOutput("120 ").
+			apply(keepVM(&compacted)),
+	}.run(t)
+	require.NotNil(t, plain, "must run plain VM")
+	require.NotNil(t, compacted, "must run compacted VM")
+	assert.Less(t, compacted.Stats().Pages, plain.Stats().Pages, "expected fewer pages when compacting")
+
+	plain.Compact()
+	assert.Equal(t, compacted.Stats().Pages, plain.Stats().Pages, "expected same pages after compaction")
+	assert.Equal(t, compacted.Stats().Cells, plain.Stats().Cells, "expected same cells after compaction")
+}
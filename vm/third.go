@@ -1,4 +1,4 @@
-package main
+package vm
 
 import (
 	"io"
@@ -292,4 +292,4 @@ func (_thirdKernel) Open() io.Reader {
 	}{Reader: strings.NewReader(_thirdSource)}
 }
 
-var thirdKernel _thirdKernel
+var ThirdKernel _thirdKernel
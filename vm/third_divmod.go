@@ -0,0 +1,34 @@
+package vm
+
+import (
+	"io"
+	"strings"
+)
+
+// THIRD's mod costs a division, a multiplication, and a subtraction; given the
+// "/mod" primitive, it can instead cost a single division. Kernel words already
+// compiled against the prior mod, like printnum, keep using it.
+const _thirdDivModSource = `
+: mod /mod drop ;
+`
+
+type _thirdDivMod struct{}
+
+func (_thirdDivMod) Name() string { return "third-divmod" }
+
+func (_thirdDivMod) WriteTo(w io.Writer) (_ int64, err error) {
+	n, err := io.WriteString(w, _thirdDivModSource)
+	return int64(n), err
+}
+
+func (_thirdDivMod) Open() io.Reader {
+	return struct {
+		_thirdDivMod
+		io.Reader
+	}{Reader: strings.NewReader(_thirdDivModSource)}
+}
+
+// ThirdDivMod redefines THIRD's mod in terms of the "/mod" primitive, so that
+// it rounds like "/" under any DivisionMode; it is meant to be input right
+// after a THIRD kernel, to VMs created WithDivMod.
+var ThirdDivMod _thirdDivMod
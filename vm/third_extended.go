@@ -0,0 +1,47 @@
+package vm
+
+import (
+	"io"
+	"strings"
+)
+
+// The extended THIRD kernel is the same as the standard one, except that it
+// doesn't define any words provided by extended builtins; each of these
+// definitions must appear verbatim, on its own lines, in the standard kernel.
+var _thirdExtendedOmits = []string{
+	": + _x! 0 _x - - exit\n",
+	": dup _x! _x _x exit\n",
+	": swap\n  _x! _y! _x _y\n  exit\n",
+	": drop 0 * + ;\n",
+	": < - <0 ;\n",
+	": = - not ;\n",
+}
+
+var _thirdExtendedSource = omitLines(_thirdSource, _thirdExtendedOmits...)
+
+func omitLines(src string, omits ...string) string {
+	for _, omit := range omits {
+		src = strings.Replace(src, "\n"+omit, "\n", 1)
+	}
+	return src
+}
+
+type _thirdExtendedKernel struct{}
+
+func (_thirdExtendedKernel) Name() string { return "third-extended" }
+
+func (_thirdExtendedKernel) WriteTo(w io.Writer) (_ int64, err error) {
+	n, err := io.WriteString(w, _thirdExtendedSource)
+	return int64(n), err
+}
+
+func (_thirdExtendedKernel) Open() io.Reader {
+	return struct {
+		_thirdExtendedKernel
+		io.Reader
+	}{Reader: strings.NewReader(_thirdExtendedSource)}
+}
+
+// ThirdExtendedKernel is a variant of ThirdKernel for VMs created
+// WithExtendedBuiltins.
+var ThirdExtendedKernel _thirdExtendedKernel
@@ -1,4 +1,4 @@
-package main
+package vm
 
 import (
 	"flag"
@@ -13,6 +13,8 @@ import (
 	"testing"
 	"text/template"
 
+	"github.com/stretchr/testify/assert"
+
 	"github.com/jcorbin/gothird/internal/mem"
 )
 
@@ -35,6 +37,7 @@ func Test_kernel(t *testing.T) {
 			`  prog: 1028`,
 			`  dict: [1087 1082 1077 1072 1067 1062 1057 1052 1047 1042 1038 1034 1030 1024]`,
 			`  stack: []`,
+			`  stats: 2 pages, 512 cells, h <= 1092, r <= 255, 275 loads, 217 stors`,
 			`  @    0 1092 dict`,
 			`  @    1 255 ret`,
 			`  @    2 0`,
@@ -167,7 +170,7 @@ func Test_kernel(t *testing.T) {
 	`,
 		expectVMRStack(1111),
 		expectVMStack(42),
-		expectVMError(mem.LimitError{1024 * 1024, "load"}))
+		expectVMError(mem.LimitError{Addr: 1024 * 1024, Op: "load"}))
 
 	// swap two values on the top of the stack.
 	testThirdKernel.addSource("swap", `
@@ -607,7 +610,7 @@ var genThirdFlag = flag.Bool("generate-third", false,
 func Test_Third(t *testing.T) {
 	t.Skip()
 	vmTest("third").
-		withInputWriter(thirdKernel).
+		withInputWriter(ThirdKernel).
 		withNamedInput("test", `tron [
 		  11 1 do i . loop nl
 		`).
@@ -615,6 +618,83 @@ func Test_Third(t *testing.T) {
 		run(t)
 }
 
+func Test_ThirdDivMod(t *testing.T) {
+	const input = `
+		: test immediate
+			-7 3 mod . 7 -3 mod . 7 3 mod . -6 3 mod .
+			nl ;
+		test
+	`
+	expectDivMod := func(vmt vmTestCase) vmTestCase {
+		return vmt.expectThat(func(t *testing.T, vm *VM) {
+			code, _ := vm.Disasm(vm.lookup("mod") + 4)
+			assert.Contains(t, code, "/mod", "expected mod to use /mod")
+		})
+	}
+	floored := WithDivisionMode(FlooredDivision)
+	vmTestCases{
+		vmTest("truncated").withOptions(WithDivMod()).
+			withInputWriter(ThirdKernel).
+			withInputWriter(ThirdDivMod).
+			withNamedInput("test", input).
+			expectOutput("-1 1 1 0 \n").
+			apply(expectDivMod),
+
+		vmTest("floored").withOptions(floored, WithDivMod()).
+			withInputWriter(ThirdKernel).
+			withInputWriter(ThirdDivMod).
+			withNamedInput("test", input).
+			expectOutput("2 -2 1 0 \n").
+			apply(expectDivMod),
+
+		vmTest("floored extended").withOptions(floored, WithDivMod(), WithExtendedBuiltins()).
+			withInputWriter(ThirdExtendedKernel).
+			withInputWriter(ThirdDivMod).
+			withNamedInput("test", input).
+			expectOutput("2 -2 1 0 \n").
+			apply(expectDivMod),
+	}.run(t)
+}
+
+func Test_ThirdExtendedKernel(t *testing.T) {
+	for _, def := range _thirdExtendedOmits {
+		assert.Equal(t, 1, strings.Count(_thirdSource, "\n"+def), "expected kernel to define %q exactly once", def)
+		name := strings.Fields(def)[1]
+		assert.NotContains(t, _thirdExtendedSource, "\n: "+name+" ", "expected extended kernel to omit %q", name)
+		assert.NotContains(t, _thirdExtendedSource, "\n: "+name+"\n", "expected extended kernel to omit %q", name)
+	}
+
+	const input = `
+		: test immediate
+			3 4 + .
+			-7 2 swap drop dup * .
+			1 2 < . 2 1 < . 5 5 = .
+			-12 3 / . -12 3 mod .
+			nl ;
+		test
+	`
+	var steps, extSteps uint64
+	expectSteps := func(steps *uint64) func(vmTestCase) vmTestCase {
+		return func(vmt vmTestCase) vmTestCase {
+			return vmt.expectThat(func(t *testing.T, vm *VM) { *steps = vm.Steps() })
+		}
+	}
+	vmTestCases{
+		vmTest("standard").
+			withInputWriter(ThirdKernel).
+			withNamedInput("test", input).
+			expectOutput("7 4 1 0 1 -4 0 \n").
+			apply(expectSteps(&steps)),
+
+		vmTest("extended").withOptions(WithExtendedBuiltins()).
+			withInputWriter(ThirdExtendedKernel).
+			withNamedInput("test", input).
+			expectOutput("7 4 1 0 1 -4 0 \n").
+			apply(expectSteps(&extSteps)),
+	}.run(t)
+	assert.Less(t, extSteps, steps, "expected extended kernel to take fewer steps")
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 
@@ -623,7 +703,7 @@ func TestMain(m *testing.M) {
 	if *genThirdFlag && exitCode == 0 {
 		if err := generateFile("third.go", func(w io.Writer) error {
 			return withGoimports(w, func(w io.Writer) error {
-				io.WriteString(w, "package main\n\n")
+				io.WriteString(w, "package vm\n\n")
 				fmt.Fprintf(w, "//go:generate go test -generate-third .\n\n")
 				return kernelTmpl.Execute(w, testThirdKernel)
 			})
@@ -666,7 +746,7 @@ var {{ .VarName }} {{ .TypeName }}
 func (k kernel) FileName() string   { return k.name }
 func (k kernel) SourceName() string { return "_" + k.name + "Source" }
 func (k kernel) TypeName() string   { return "_" + k.name + "Kernel" }
-func (k kernel) VarName() string    { return k.name + "Kernel" }
+func (k kernel) VarName() string    { return strings.Title(k.name) + "Kernel" }
 func (k kernel) QuotedSource() string {
 	const includeNameComments = false
 	var sb strings.Builder
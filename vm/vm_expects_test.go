@@ -1,4 +1,4 @@
-package main
+package vm
 
 import (
 	"io"
@@ -7,7 +7,7 @@ import (
 
 // @generated from vm_test.go
 
-//go:generate go run scripts/gen_vm_expects.go -- vm_test.go vm_expects_test.go
+//go:generate go run ../scripts/gen_vm_expects.go -- vm_test.go vm_expects_test.go
 
 func withVMOptions(opts ...VMOption) func(vmTestCase) vmTestCase {
 	return func(vmt vmTestCase) vmTestCase {
@@ -1,4 +1,4 @@
-package main
+package vm
 
 import (
 	"context"
@@ -21,6 +21,9 @@ import (
 	"github.com/jcorbin/gothird/internal/panicerr"
 )
 
+// testBuiltins is the minimal input that defines each builtin word.
+const testBuiltins = "exit : immediate _read @ ! - * / <0 echo key pick\n"
+
 type vmTestCases []vmTestCase
 
 func (vmts vmTestCases) run(t *testing.T) {
@@ -321,6 +324,22 @@ func (vmt vmTestCase) expectOutput(output string) vmTestCase {
 	return vmt
 }
 
+func (vmt vmTestCase) expectRecovered(errs ...string) vmTestCase {
+	vmt.opts = append(vmt.opts, func(vmt *vmTestCase, t *testing.T) VMOption {
+		var got []string
+		vmt.expect = append(vmt.expect, func(t *testing.T, vm *VM) {
+			assert.Equal(t, errs, got, "expected recovered errors")
+		})
+		return WithRecovery(func(err error) { got = append(got, err.Error()) })
+	})
+	return vmt
+}
+
+func (vmt vmTestCase) expectThat(check func(t *testing.T, vm *VM)) vmTestCase {
+	vmt.expect = append(vmt.expect, check)
+	return vmt
+}
+
 func (vmt vmTestCase) expectDump(dump string) vmTestCase {
 	vmt.expect = append(vmt.expect, func(t *testing.T, vm *VM) {
 		var out strings.Builder